@@ -8,5 +8,9 @@ routers.go: router binding and core logic
 serializers.go: definition the schema of return data
 
 validators.go: definition the validator of form data
+
+search.go: the full-text index of articles and the search queries for each database
+
+markdown.go: renders article bodies to sanitized HTML, with their table of contents and reading time
 */
 package articles
@@ -0,0 +1,167 @@
+package articles
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// An article body as HTML, with what clients would otherwise work out from it themselves.
+type RenderedBody struct {
+	HTML        string
+	TOC         []TOCEntry
+	ReadingTime int // minutes
+}
+
+// A heading of the body, ID is the anchor the HTML gives it.
+type TOCEntry struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+	ID    string `json:"id"`
+}
+
+// How fast articles are read, in words per minute.
+const readingSpeed = 200
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+)
+
+// The user generated content policy, plus the heading anchors the TOC points at and the
+// language classes of code blocks. Raw HTML is already dropped by goldmark, this is what
+// keeps links and attributes safe.
+var sanitizer = func() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowAttrs("id").Matching(regexp.MustCompile(`^[\p{L}\p{M}\p{N}_-]+$`)).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[a-zA-Z0-9_+-]+$`)).OnElements("code")
+	return policy
+}()
+
+// Turn a Markdown body into sanitized HTML. The same body renders once, so every revision
+// of an article is rendered the first time it is asked for and then comes from the cache.
+func RenderBody(body string) RenderedBody {
+	key := sha256.Sum256([]byte(body))
+	if rendered, ok := renderCache.get(key); ok {
+		return rendered
+	}
+	rendered := renderBody(body)
+	renderCache.add(key, rendered)
+	return rendered
+}
+
+func renderBody(body string) RenderedBody {
+	source := []byte(body)
+	context := parser.NewContext(parser.WithIDs(headingIDs{}))
+	document := markdown.Parser().Parse(text.NewReader(source), parser.WithContext(context))
+	var rendered RenderedBody
+	rendered.TOC = []TOCEntry{}
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if heading, ok := node.(*ast.Heading); ok && entering {
+			id, _ := heading.AttributeString("id")
+			idBytes, _ := id.([]byte)
+			rendered.TOC = append(rendered.TOC, TOCEntry{Level: heading.Level, Text: string(heading.Text(source)), ID: string(idBytes)})
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	var html bytes.Buffer
+	if err := markdown.Renderer().Render(&html, source, document); err != nil {
+		html.Reset()
+	}
+	rendered.HTML = sanitizer.Sanitize(html.String())
+	words := len(strings.Fields(body))
+	rendered.ReadingTime = (words + readingSpeed - 1) / readingSpeed
+	if rendered.ReadingTime == 0 {
+		rendered.ReadingTime = 1
+	}
+	return rendered
+}
+
+// The heading anchors of one body, goldmark asks for them as it parses so the HTML and the
+// TOC get the same ones. Like goldmark's own, but letters and digits of any script stay in:
+// "Привет мир" is "привет-мир" rather than "-".
+type headingIDs map[string]bool
+
+func (ids headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	var id strings.Builder
+	for _, r := range strings.TrimSpace(string(value)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r):
+			id.WriteRune(unicode.ToLower(r))
+		case unicode.IsSpace(r) || r == '-' || r == '_':
+			id.WriteRune('-')
+		}
+	}
+	base := id.String()
+	if base == "" {
+		base = "id"
+		if kind == ast.KindHeading {
+			base = "heading"
+		}
+	}
+	result := base
+	for i := 1; ids[result]; i++ {
+		result = fmt.Sprintf("%s-%d", base, i)
+	}
+	ids[result] = true
+	return []byte(result)
+}
+
+func (ids headingIDs) Put(value []byte) {
+	ids[string(value)] = true
+}
+
+// How many rendered bodies are kept, the least recently used goes first.
+const renderCacheSize = 512
+
+type lru struct {
+	sync.Mutex
+	entries map[[32]byte]*list.Element
+	order   *list.List // most recently used first
+}
+
+type lruEntry struct {
+	key      [32]byte
+	rendered RenderedBody
+}
+
+var renderCache = lru{entries: make(map[[32]byte]*list.Element), order: list.New()}
+
+func (c *lru) get(key [32]byte) (RenderedBody, bool) {
+	c.Lock()
+	defer c.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return RenderedBody{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(lruEntry).rendered, true
+}
+
+func (c *lru) add(key [32]byte, rendered RenderedBody) {
+	c.Lock()
+	defer c.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(lruEntry{key, rendered})
+	if c.order.Len() > renderCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(lruEntry).key)
+	}
+}
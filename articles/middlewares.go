@@ -0,0 +1,80 @@
+package articles
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+// Anything written by an ArticleUserModel, so the same permission check can guard
+// articles, comments and whatever else gets an author later.
+type Ownable interface {
+	OwnerID() uint
+}
+
+func (article ArticleModel) OwnerID() uint {
+	return article.AuthorID
+}
+
+func (comment CommentModel) OwnerID() uint {
+	return comment.AuthorID
+}
+
+// Make sure the current user is the author of the resource before touching it.
+// It writes the 401/403 response itself, so the handler only has to return.
+// 	if !RequireOwner(c, "article", articleModel) { return }
+func RequireOwner(c *gin.Context, key string, resource Ownable) bool {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, common.NewError(key, errors.New("Require auth")))
+		return false
+	}
+	if author := findArticleUserModel(myUserModel); author.ID == 0 || author.ID != resource.OwnerID() {
+		c.AbortWithStatusJSON(http.StatusForbidden, common.NewError(key, errors.New("You are not the author")))
+		return false
+	}
+	return true
+}
+
+// Whether the current user may see the article: everyone once it is published, only its
+// author before. Handlers answer 404 otherwise, so drafts can't be told from missing slugs.
+func canSee(c *gin.Context, article ArticleModel) bool {
+	if article.IsPublished(time.Now()) {
+		return true
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	author := findArticleUserModel(myUserModel)
+	return author.ID != 0 && author.ID == article.AuthorID
+}
+
+// Whether the current user is one of articles.moderators.
+func isModerator(c *gin.Context) bool {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		return false
+	}
+	for _, username := range common.GetConfig().Articles.Moderators {
+		if strings.EqualFold(username, myUserModel.Username) { // usernames are unique whatever their case
+			return true
+		}
+	}
+	return false
+}
+
+// Keep users the author blocked from favoriting, reacting to or commenting on the article.
+// It writes the 403 itself, like RequireOwner.
+// 	if blockedByAuthor(c, "comment", articleModel) { return }
+func blockedByAuthor(c *gin.Context, key string, article ArticleModel) bool {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 || !article.Author.UserModel.IsBlocking(myUserModel) {
+		return false
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, common.NewError(key, errors.New("You are blocked by the author")))
+	return true
+}
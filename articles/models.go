@@ -1,11 +1,13 @@
 package articles
 
 import (
-	_ "fmt"
+	"fmt"
+	"github.com/gosimple/slug"
 	"github.com/jinzhu/gorm"
 	"realworld-backend/common"
 	"realworld-backend/users"
-	"strconv"
+	"strings"
+	"time"
 )
 
 type ArticleModel struct {
@@ -18,6 +20,87 @@ type ArticleModel struct {
 	AuthorID    uint
 	Tags        []TagModel     `gorm:"many2many:article_tags;"`
 	Comments    []CommentModel `gorm:"ForeignKey:ArticleID"`
+	Status      string         `gorm:"size:16;index;default:'published'"`
+	PublishAt   *time.Time     // when a scheduled article goes public
+}
+
+// Only published articles are listed and shown to others, drafts and scheduled articles
+// are for their author's eyes. A scheduled article counts as published from PublishAt on,
+// PublishScheduled just makes it official.
+const (
+	ArticleDraft     = "draft"
+	ArticleScheduled = "scheduled"
+	ArticlePublished = "published"
+)
+
+// Whether everyone may see the article at the given time.
+func (model ArticleModel) IsPublished(now time.Time) bool {
+	if model.Status == ArticleScheduled {
+		return model.PublishAt != nil && !model.PublishAt.After(now)
+	}
+	return model.Status == ArticlePublished
+}
+
+// The where clause keeping the published articles of a query on article_models, see
+// IsPublished.
+//
+//	db.Where(publishedWhere(), time.Now())
+func publishedWhere() string {
+	return "(article_models.status = 'published' OR (article_models.status = 'scheduled' AND article_models.publish_at <= ?))"
+}
+
+// Publish the scheduled articles whose time came, the background publisher calls it
+// every minute. It returns how many it published.
+func PublishScheduled() (int64, error) {
+	db := common.GetDB()
+	result := db.Model(&ArticleModel{}).Where("status = ? AND publish_at <= ?", ArticleScheduled, time.Now()).
+		UpdateColumns(map[string]interface{}{"status": ArticlePublished, "publish_at": nil})
+	return result.RowsAffected, result.Error
+}
+
+// A slug an article had before its title changed. Old links keep finding the article,
+// and no other article may take the slug over.
+type ArticleSlugModel struct {
+	ID        uint   `gorm:"primary_key"`
+	Slug      string `gorm:"unique_index"`
+	ArticleID uint   `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// The title, description and body of an article after one of its saves. Revisions are
+// numbered from 1, the article as it was created, and never change.
+type ArticleRevisionModel struct {
+	ID          uint `gorm:"primary_key"`
+	ArticleID   uint `gorm:"unique_index:idx_article_revision_number"`
+	Number      uint `gorm:"unique_index:idx_article_revision_number"`
+	Title       string
+	Description string `gorm:"size:2048"`
+	Body        string `gorm:"size:2048"`
+	Editor      ArticleUserModel
+	EditorID    uint
+	Changed     string // the fields this save changed, comma separated
+	CreatedAt   time.Time
+}
+
+// One reaction of a user to an article. A user may react with several reactions, each once.
+type ReactionModel struct {
+	ID        uint `gorm:"primary_key"`
+	ArticleID uint `gorm:"unique_index:idx_article_reaction"`
+	User      ArticleUserModel
+	UserID    uint   `gorm:"unique_index:idx_article_reaction"`
+	Reaction  string `gorm:"size:32;unique_index:idx_article_reaction"`
+	CreatedAt time.Time
+}
+
+// An article a user saved for later, in one of their folders or none (""). Only the user
+// sees their bookmarks.
+type BookmarkModel struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"unique_index:idx_user_bookmark"` // a users.UserModel
+	ArticleID uint   `gorm:"unique_index:idx_user_bookmark"`
+	Folder    string `gorm:"size:64"`
+	CreatedAt time.Time
+	Article   ArticleModel // only loaded by GetBookmarks
 }
 
 type ArticleUserModel struct {
@@ -49,6 +132,42 @@ type CommentModel struct {
 	Author    ArticleUserModel
 	AuthorID  uint
 	Body      string `gorm:"size:2048"`
+	ParentID  *uint  `gorm:"index"` // nil at the top of the thread
+	Depth     uint   `gorm:"default:0"`
+	Removed   bool   `gorm:"default:false"` // deleted, kept as a placeholder for its replies
+	EditedAt  *time.Time
+}
+
+// The body a comment had before one of its edits, and who replaced it when.
+type CommentEditModel struct {
+	ID        uint   `gorm:"primary_key"`
+	CommentID uint   `gorm:"index"`
+	Body      string `gorm:"size:2048"`
+	Editor    ArticleUserModel
+	EditorID  uint
+	CreatedAt time.Time
+}
+
+// How deep replies nest, a top level comment is at depth 0.
+const maxCommentDepth = 5
+
+// Migrate the schema of database if needed
+func AutoMigrate() {
+	db := common.GetDB()
+
+	db.AutoMigrate(&ArticleModel{})
+	db.AutoMigrate(&TagModel{})
+	db.AutoMigrate(&FavoriteModel{})
+	db.AutoMigrate(&ArticleUserModel{})
+	db.AutoMigrate(&CommentModel{})
+	db.AutoMigrate(&CommentEditModel{})
+	db.AutoMigrate(&ReactionModel{})
+	db.AutoMigrate(&BookmarkModel{})
+	db.AutoMigrate(&ArticleSlugModel{})
+	db.AutoMigrate(&ArticleRevisionModel{})
+	if err := createSearchIndex(db); err != nil {
+		fmt.Println("search index err: ", err)
+	}
 }
 
 func GetArticleUserModel(userModel users.UserModel) ArticleUserModel {
@@ -64,23 +183,17 @@ func GetArticleUserModel(userModel users.UserModel) ArticleUserModel {
 	return articleUserModel
 }
 
-func (article ArticleModel) favoritesCount() uint {
-	db := common.GetDB()
-	var count uint
-	db.Model(&FavoriteModel{}).Where(FavoriteModel{
-		FavoriteID: article.ID,
-	}).Count(&count)
-	return count
-}
-
-func (article ArticleModel) isFavoriteBy(user ArticleUserModel) bool {
+// The ArticleUserModel of the user, without making one when they have none yet: its ID is
+// 0 then. For checks, which shouldn't write.
+func findArticleUserModel(userModel users.UserModel) ArticleUserModel {
+	var articleUserModel ArticleUserModel
+	if userModel.ID == 0 {
+		return articleUserModel
+	}
 	db := common.GetDB()
-	var favorite FavoriteModel
-	db.Where(FavoriteModel{
-		FavoriteID:   article.ID,
-		FavoriteByID: user.ID,
-	}).First(&favorite)
-	return favorite.ID != 0
+	db.Where("user_model_id = ?", userModel.ID).First(&articleUserModel)
+	articleUserModel.UserModel = userModel
+	return articleUserModel
 }
 
 func (article ArticleModel) favoriteBy(user ArticleUserModel) error {
@@ -102,17 +215,173 @@ func (article ArticleModel) unFavoriteBy(user ArticleUserModel) error {
 	return err
 }
 
+func (article ArticleModel) reactBy(user ArticleUserModel, reaction string) error {
+	db := common.GetDB()
+	var model ReactionModel
+	return db.FirstOrCreate(&model, &ReactionModel{ArticleID: article.ID, UserID: user.ID, Reaction: reaction}).Error
+}
+
+func (article ArticleModel) unreactBy(user ArticleUserModel, reaction string) error {
+	db := common.GetDB()
+	return db.Where(ReactionModel{ArticleID: article.ID, UserID: user.ID, Reaction: reaction}).Delete(ReactionModel{}).Error
+}
+
+// A page of who reacted to the article with the reaction, latest first.
+func (article ArticleModel) getReactions(reaction string, page common.Page) ([]ReactionModel, int, common.Cursors, error) {
+	db := common.GetDB()
+	var models []ReactionModel
+	var count int
+	query := db.Model(&ReactionModel{}).Where(ReactionModel{ArticleID: article.ID, Reaction: reaction})
+	query.Count(&count)
+	cursors, err := page.Find(query.Preload("User.UserModel"), &models, "", true)
+	return models, count, cursors, err
+}
+
+// The configured reaction of this name, readers can't use any other.
+func findReactionConfig(name string) (common.ReactionConfig, bool) {
+	for _, reaction := range common.GetConfig().Articles.Reactions {
+		if reaction.Name == name {
+			return reaction, true
+		}
+	}
+	return common.ReactionConfig{}, false
+}
+
+// How many of each reaction the articles got, in one query.
+func reactionCounts(ids []uint) map[uint]map[string]uint {
+	db := common.GetDB()
+	counts := make(map[uint]map[string]uint)
+	if len(ids) == 0 {
+		return counts
+	}
+	rows, err := db.Model(&ReactionModel{}).Select("article_id, reaction, count(*)").Where("article_id IN (?)", ids).Group("article_id, reaction").Rows()
+	if err != nil {
+		return counts
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, count uint
+		var reaction string
+		rows.Scan(&id, &reaction, &count)
+		if counts[id] == nil {
+			counts[id] = make(map[string]uint)
+		}
+		counts[id][reaction] = count
+	}
+	return counts
+}
+
+// Which reactions the user gave each of the articles, in one query.
+func reactedBy(ids []uint, user ArticleUserModel) map[uint]map[string]bool {
+	db := common.GetDB()
+	reacted := make(map[uint]map[string]bool)
+	if len(ids) == 0 || user.ID == 0 {
+		return reacted
+	}
+	var models []ReactionModel
+	db.Where("article_id IN (?) AND user_id = ?", ids, user.ID).Find(&models)
+	for _, model := range models {
+		if reacted[model.ArticleID] == nil {
+			reacted[model.ArticleID] = make(map[string]bool)
+		}
+		reacted[model.ArticleID][model.Reaction] = true
+	}
+	return reacted
+}
+
+// Bookmark the article, or move the bookmark to another folder.
+func (article ArticleModel) bookmarkBy(user users.UserModel, folder string) error {
+	db := common.GetDB()
+	var model BookmarkModel
+	return db.Where(BookmarkModel{UserID: user.ID, ArticleID: article.ID}).Assign(map[string]interface{}{"folder": folder}).FirstOrCreate(&model).Error
+}
+
+func (article ArticleModel) unbookmarkBy(user users.UserModel) error {
+	db := common.GetDB()
+	return db.Where(BookmarkModel{UserID: user.ID, ArticleID: article.ID}).Delete(BookmarkModel{}).Error
+}
+
+// Which of the articles the user bookmarked, in one query.
+func bookmarkedBy(ids []uint, user users.UserModel) map[uint]bool {
+	db := common.GetDB()
+	bookmarked := make(map[uint]bool)
+	if len(ids) == 0 || user.ID == 0 {
+		return bookmarked
+	}
+	var articleIDs []uint
+	db.Model(&BookmarkModel{}).Where("article_id IN (?) AND user_id = ?", ids, user.ID).Pluck("article_id", &articleIDs)
+	for _, id := range articleIDs {
+		bookmarked[id] = true
+	}
+	return bookmarked
+}
+
+// The user's bookmarks of articles they can still see: published ones, their own drafts,
+// and none of private authors they don't follow. Those left out keep their bookmark.
+func visibleBookmarks(tx *gorm.DB, user users.UserModel) *gorm.DB {
+	return tx.Model(&BookmarkModel{}).
+		Joins("JOIN article_models ON article_models.id = bookmark_models.article_id AND article_models.deleted_at IS NULL").
+		Where("bookmark_models.user_id = ?", user.ID).
+		Where("("+publishedWhere()+" OR article_models.author_id = ?)", time.Now(), GetArticleUserModel(user).ID).
+		Where("article_models.author_id NOT IN ?", privateAuthors(tx, user).SubQuery())
+}
+
+// A page of the articles the user bookmarked, all of them or those of one folder, the
+// latest bookmarked first. See visibleBookmarks for those left out.
+func GetBookmarks(user users.UserModel, folder *string, page common.Page) ([]ArticleModel, int, common.Cursors, error) {
+	db := common.GetDB()
+	var bookmarks []BookmarkModel
+	var count int
+
+	tx := db.Begin()
+	query := visibleBookmarks(tx, user)
+	if folder != nil {
+		query = query.Where("bookmark_models.folder = ?", *folder)
+	}
+	query.Count(&count)
+	query = query.Select("bookmark_models.*").Preload("Article.Author.UserModel").Preload("Article.Tags")
+	cursors, err := page.Find(query, &bookmarks, "CreatedAt", true)
+	if err != nil {
+		tx.Rollback()
+		return nil, count, cursors, err
+	}
+	models := make([]ArticleModel, len(bookmarks))
+	for i, bookmark := range bookmarks {
+		models[i] = bookmark.Article
+	}
+	err = tx.Commit().Error
+	return models, count, cursors, err
+}
+
+type BookmarkFolder struct {
+	Folder string
+	Count  int
+}
+
+// The folders the user has bookmarks in, by name, "" being the bookmarks without folder.
+// They count the bookmarks GetBookmarks lists.
+func GetBookmarkFolders(user users.UserModel) ([]BookmarkFolder, error) {
+	db := common.GetDB()
+	var folders []BookmarkFolder
+	err := visibleBookmarks(db, user).Select("bookmark_models.folder, count(*) AS count").
+		Group("bookmark_models.folder").Order("bookmark_models.folder").Scan(&folders).Error
+	return folders, err
+}
+
 func SaveOne(data interface{}) error {
 	db := common.GetDB()
 	err := db.Save(data).Error
-	return err
+	return common.NormalizeDBError(err)
 }
 
 func FindOneArticle(condition interface{}) (ArticleModel, error) {
 	db := common.GetDB()
 	var model ArticleModel
 	tx := db.Begin()
-	tx.Where(condition).First(&model)
+	if err := tx.Where(condition).First(&model).Error; err != nil {
+		tx.Rollback()
+		return model, err
+	}
 	tx.Model(&model).Related(&model.Author, "Author")
 	tx.Model(&model.Author).Related(&model.Author.UserModel)
 	tx.Model(&model).Related(&model.Tags, "Tags")
@@ -120,16 +389,80 @@ func FindOneArticle(condition interface{}) (ArticleModel, error) {
 	return model, err
 }
 
-func (self *ArticleModel) getComments() error {
+// The article an old slug used to point at, see ArticleSlugModel.
+func FindOneArticleBySlugHistory(slug string) (ArticleModel, error) {
+	db := common.GetDB()
+	var history ArticleSlugModel
+	if err := db.Where(ArticleSlugModel{Slug: slug}).First(&history).Error; err != nil {
+		return ArticleModel{}, err
+	}
+	return FindOneArticle(&ArticleModel{Model: gorm.Model{ID: history.ArticleID}})
+}
+
+func FindOneComment(condition interface{}) (CommentModel, error) {
+	db := common.GetDB()
+	var model CommentModel
+	err := db.Where(condition).Preload("Author.UserModel").First(&model).Error
+	return model, err
+}
+
+// Replace the body of the comment, keeping the old one in its edit history. The same body
+// again is not an edit.
+func (model *CommentModel) Update(body string, editor ArticleUserModel) error {
+	if body == model.Body {
+		return nil
+	}
 	db := common.GetDB()
+	now := time.Now()
 	tx := db.Begin()
-	tx.Model(self).Related(&self.Comments, "Comments")
-	for i, _ := range self.Comments {
-		tx.Model(&self.Comments[i]).Related(&self.Comments[i].Author, "Author")
-		tx.Model(&self.Comments[i].Author).Related(&self.Comments[i].Author.UserModel)
+	err := tx.Create(&CommentEditModel{CommentID: model.ID, Body: model.Body, EditorID: editor.ID}).Error
+	if err == nil {
+		err = tx.Model(model).Updates(map[string]interface{}{"body": body, "edited_at": now}).Error
 	}
-	err := tx.Commit().Error
-	return err
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// A page of the comment's edit history, newest first.
+func (model CommentModel) getEdits(page common.Page) ([]CommentEditModel, int, common.Cursors, error) {
+	db := common.GetDB()
+	var models []CommentEditModel
+	var count int
+	query := db.Model(&CommentEditModel{}).Where("comment_id = ?", model.ID)
+	query.Count(&count)
+	cursors, err := page.Find(query.Preload("Editor.UserModel"), &models, "", true)
+	return models, count, cursors, err
+}
+
+// Load a page of the article's top level comments, oldest first, followed by all their
+// replies, one query per level. Comments of users the viewer blocked or muted are left out,
+// along with the replies under them.
+func (self *ArticleModel) getComments(page common.Page, viewer users.UserModel) (common.Cursors, error) {
+	db := common.GetDB()
+	if viewer.ID != 0 {
+		db = db.Where("author_id NOT IN ?", hiddenAuthors(db, viewer).SubQuery())
+	}
+	query := db.Where(CommentModel{ArticleID: self.ID}).Where("parent_id IS NULL").Preload("Author.UserModel")
+	cursors, err := page.Find(query, &self.Comments, "", false)
+	parents := self.Comments
+	for err == nil && len(parents) > 0 {
+		var ids []uint
+		for _, comment := range parents {
+			ids = append(ids, comment.ID)
+		}
+		parents = nil
+		err = db.Where("parent_id IN (?)", ids).Preload("Author.UserModel").Order("id").Find(&parents).Error
+		self.Comments = append(self.Comments, parents...)
+	}
+	return cursors, err
+}
+
+// The hub topic of the article's new comments.
+func commentsTopic(articleID uint) string {
+	return fmt.Sprintf("articles/%v/comments", articleID)
 }
 
 func getAllTags() ([]TagModel, error) {
@@ -139,100 +472,138 @@ func getAllTags() ([]TagModel, error) {
 	return models, err
 }
 
-func FindManyArticle(tag, author, limit, offset, favorited string) ([]ArticleModel, int, error) {
+// What the article list can be narrowed down to, every filter that is set applies.
+type ArticleFilter struct {
+	Tags          []string // lower case, without duplicates
+	AllTags       bool     // articles need every tag, not just one of them
+	Author        string
+	Favorited     string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Viewer        users.UserModel // leaves out the authors this user blocked, muted or can't see
+}
+
+func FindManyArticle(filter ArticleFilter, page common.Page) ([]ArticleModel, int, common.Cursors, error) {
 	db := common.GetDB()
 	var models []ArticleModel
 	var count int
 
-	offset_int, err := strconv.Atoi(offset)
-	if err != nil {
-		offset_int = 0
-	}
-
-	limit_int, err := strconv.Atoi(limit)
-	if err != nil {
-		limit_int = 20
-	}
-
 	tx := db.Begin()
-	if tag != "" {
-		var tagModel TagModel
-		tx.Where(TagModel{Tag: tag}).First(&tagModel)
-		if tagModel.ID != 0 {
-			tx.Model(&tagModel).Offset(offset_int).Limit(limit_int).Related(&models, "ArticleModels")
-			count = tx.Model(&tagModel).Association("ArticleModels").Count()
-		}
-	} else if author != "" {
-		var userModel users.UserModel
-		tx.Where(users.UserModel{Username: author}).First(&userModel)
-		articleUserModel := GetArticleUserModel(userModel)
-
-		if articleUserModel.ID != 0 {
-			count = tx.Model(&articleUserModel).Association("ArticleModels").Count()
-			tx.Model(&articleUserModel).Offset(offset_int).Limit(limit_int).Related(&models, "ArticleModels")
-		}
-	} else if favorited != "" {
-		var userModel users.UserModel
-		tx.Where(users.UserModel{Username: favorited}).First(&userModel)
-		articleUserModel := GetArticleUserModel(userModel)
-		if articleUserModel.ID != 0 {
-			var favoriteModels []FavoriteModel
-			tx.Where(FavoriteModel{
-				FavoriteByID: articleUserModel.ID,
-			}).Offset(offset_int).Limit(limit_int).Find(&favoriteModels)
-
-			count = tx.Model(&articleUserModel).Association("FavoriteModels").Count()
-			for _, favorite := range favoriteModels {
-				var model ArticleModel
-				tx.Model(&favorite).Related(&model, "Favorite")
-				models = append(models, model)
-			}
+	query := tx.Model(&ArticleModel{}).Where(publishedWhere(), time.Now())
+	if len(filter.Tags) != 0 {
+		tagged := tx.Table("article_tags").Select("article_tags.article_model_id").
+			Joins("JOIN tag_models ON tag_models.id = article_tags.tag_model_id").
+			Where("LOWER(tag_models.tag) IN (?)", filter.Tags)
+		if filter.AllTags {
+			tagged = tagged.Group("article_tags.article_model_id").Having("COUNT(DISTINCT LOWER(tag_models.tag)) = ?", len(filter.Tags))
 		}
-	} else {
-		db.Model(&models).Count(&count)
-		db.Offset(offset_int).Limit(limit_int).Find(&models)
+		query = query.Where("article_models.id IN ?", tagged.SubQuery())
 	}
-
-	for i, _ := range models {
-		tx.Model(&models[i]).Related(&models[i].Author, "Author")
-		tx.Model(&models[i].Author).Related(&models[i].Author.UserModel)
-		tx.Model(&models[i]).Related(&models[i].Tags, "Tags")
+	if filter.Author != "" {
+		query = query.Where("article_models.author_id IN ?", articleUsersNamed(tx, filter.Author).SubQuery())
+	}
+	if filter.Favorited != "" {
+		favorites := tx.Model(&FavoriteModel{}).Select("favorite_id").Where("favorite_by_id IN ?", articleUsersNamed(tx, filter.Favorited).SubQuery())
+		query = query.Where("article_models.id IN ?", favorites.SubQuery())
+	}
+	if !filter.CreatedAfter.IsZero() {
+		query = query.Where("article_models.created_at > ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query = query.Where("article_models.created_at < ?", filter.CreatedBefore)
+	}
+	if filter.Viewer.ID != 0 {
+		query = query.Where("article_models.author_id NOT IN ?", hiddenAuthors(tx, filter.Viewer).SubQuery())
+	}
+	query = query.Where("article_models.author_id NOT IN ?", privateAuthors(tx, filter.Viewer).SubQuery())
+	query.Count(&count)
+	cursors, err := page.Find(preloadArticles(query), &models, "CreatedAt", true)
+	if err != nil {
+		tx.Rollback()
+		return models, count, cursors, err
 	}
 	err = tx.Commit().Error
-	return models, count, err
+	return models, count, cursors, err
 }
 
-func (self *ArticleUserModel) GetArticleFeed(limit, offset string) ([]ArticleModel, int, error) {
+// The ids of the ArticleUserModel of the users the viewer blocked or muted.
+func hiddenAuthors(tx *gorm.DB, viewer users.UserModel) *gorm.DB {
+	return tx.Model(&ArticleUserModel{}).Select("article_user_models.id").Where("user_model_id IN ?", viewer.HiddenUsers())
+}
+
+// The ids of the ArticleUserModel of the private users the viewer doesn't follow. The feed
+// has no need for it, it only has followed authors.
+func privateAuthors(tx *gorm.DB, viewer users.UserModel) *gorm.DB {
+	return tx.Model(&ArticleUserModel{}).Select("article_user_models.id").Where("user_model_id IN ?", viewer.PrivateUsers())
+}
+
+// The ids of the ArticleUserModel of the user with this username, whatever its case.
+func articleUsersNamed(tx *gorm.DB, username string) *gorm.DB {
+	return tx.Model(&ArticleUserModel{}).Select("article_user_models.id").
+		Joins("JOIN user_models ON user_models.id = article_user_models.user_model_id").
+		Where(common.LowerEqual("user_models.username"), username)
+}
+
+// Load a page of the articles of the users self follows, the latest updated first.
+func (self *ArticleUserModel) GetArticleFeed(page common.Page) ([]ArticleModel, int, common.Cursors, error) {
 	db := common.GetDB()
 	var models []ArticleModel
 	var count int
 
-	offset_int, err := strconv.Atoi(offset)
+	tx := db.Begin()
+	followings := tx.Model(&users.FollowModel{}).Select("following_id").Where("followed_by_id = ?", self.UserModelID)
+	authors := tx.Model(&ArticleUserModel{}).Select("id").Where("user_model_id IN ?", followings.SubQuery())
+	query := tx.Model(&ArticleModel{}).Where("author_id IN ?", authors.SubQuery()).Where(publishedWhere(), time.Now()).
+		Where("author_id NOT IN ?", hiddenAuthors(tx, self.UserModel).SubQuery())
+	query.Count(&count)
+	cursors, err := page.Find(preloadArticles(query), &models, "UpdatedAt", true)
 	if err != nil {
-		offset_int = 0
+		tx.Rollback()
+		return models, count, cursors, err
 	}
-	limit_int, err := strconv.Atoi(limit)
+	err = tx.Commit().Error
+	return models, count, cursors, err
+}
+
+// Load the authors and tags along with a page of articles, one query per association
+// whatever the page size.
+func preloadArticles(query *gorm.DB) *gorm.DB {
+	return query.Preload("Author.UserModel").Preload("Tags")
+}
+
+// How many times each of the articles is favorited, in one query.
+func favoritesCounts(ids []uint) map[uint]uint {
+	db := common.GetDB()
+	counts := make(map[uint]uint)
+	if len(ids) == 0 {
+		return counts
+	}
+	rows, err := db.Model(&FavoriteModel{}).Select("favorite_id, count(*)").Where("favorite_id IN (?)", ids).Group("favorite_id").Rows()
 	if err != nil {
-		limit_int = 20
+		return counts
 	}
-
-	tx := db.Begin()
-	followings := self.UserModel.GetFollowings()
-	var articleUserModels []uint
-	for _, following := range followings {
-		articleUserModel := GetArticleUserModel(following)
-		articleUserModels = append(articleUserModels, articleUserModel.ID)
+	defer rows.Close()
+	for rows.Next() {
+		var id, count uint
+		rows.Scan(&id, &count)
+		counts[id] = count
 	}
+	return counts
+}
 
-	tx.Where("author_id in (?)", articleUserModels).Order("updated_at desc").Offset(offset_int).Limit(limit_int).Find(&models)
-
-	for i, _ := range models {
-		tx.Model(&models[i]).Related(&models[i].Author, "Author")
-		tx.Model(&models[i].Author).Related(&models[i].Author.UserModel)
-		tx.Model(&models[i]).Related(&models[i].Tags, "Tags")
+// Which of the articles the user favorited, in one query.
+func favoritedBy(ids []uint, user ArticleUserModel) map[uint]bool {
+	db := common.GetDB()
+	favorited := make(map[uint]bool)
+	if len(ids) == 0 || user.ID == 0 {
+		return favorited
 	}
-	err = tx.Commit().Error
-	return models, count, err
+	var favoriteIDs []uint
+	db.Model(&FavoriteModel{}).Where("favorite_id IN (?) AND favorite_by_id = ?", ids, user.ID).Pluck("favorite_id", &favoriteIDs)
+	for _, id := range favoriteIDs {
+		favorited[id] = true
+	}
+	return favorited
 }
 
 func (model *ArticleModel) setTags(tags []string) error {
@@ -240,7 +611,7 @@ func (model *ArticleModel) setTags(tags []string) error {
 	var tagList []TagModel
 	for _, tag := range tags {
 		var tagModel TagModel
-		err := db.FirstOrCreate(&tagModel, TagModel{Tag: tag}).Error
+		err := db.Where(common.LowerEqual("tag"), tag).Attrs(TagModel{Tag: tag}).FirstOrCreate(&tagModel).Error
 		if err != nil {
 			return err
 		}
@@ -250,20 +621,345 @@ func (model *ArticleModel) setTags(tags []string) error {
 	return nil
 }
 
-func (model *ArticleModel) Update(data interface{}) error {
+// How often saving retries with a fresh slug when another request took it meanwhile.
+const slugAttempts = 3
+
+// Save a new article under a slug made from its title which no other article has or had:
+// "my-title", then "my-title-2", "my-title-3"...
+func CreateArticle(model *ArticleModel) error {
+	var err error
+	for attempt := 0; attempt < slugAttempts; attempt++ {
+		if model.Slug, err = uniqueSlug(model.Title, 0); err != nil {
+			return err
+		}
+		if err = SaveOne(model); !common.IsUniqueViolation(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Save the changes in data. When the title changed the article moves to a slug for the
+// new title, and the slug it leaves goes to the history so old links keep working.
+// A Status in data is saved together with its PublishAt, even a nil one. Changes to the
+// title, description or body are recorded as a revision by data.Author.
+func (model *ArticleModel) Update(data ArticleModel) error {
+	return model.update(data, false)
+}
+
+// Go back to the title, description and body of the revision, as a new revision by editor.
+func (model *ArticleModel) Revert(revision ArticleRevisionModel, editor ArticleUserModel) error {
+	return model.update(ArticleModel{Title: revision.Title, Description: revision.Description, Body: revision.Body, Author: editor}, true)
+}
+
+// Update, where replace also saves a blank description or body.
+func (model *ArticleModel) update(data ArticleModel, replace bool) error {
 	db := common.GetDB()
-	err := db.Model(model).Update(data).Error
+	before := *model
+	editorID := data.Author.ID
+	if editorID == 0 {
+		editorID = model.AuthorID
+	}
+	var err error
+	for attempt := 0; attempt < slugAttempts; attempt++ {
+		data.Slug, err = model.Slug, nil
+		if data.Title != "" && data.Title != model.Title {
+			if data.Slug, err = uniqueSlug(data.Title, model.ID); err != nil {
+				return err
+			}
+		}
+		tx := db.Begin()
+		if data.Slug != model.Slug {
+			// The article may come back to a slug it had before.
+			tx.Where(ArticleSlugModel{Slug: data.Slug, ArticleID: model.ID}).Delete(ArticleSlugModel{})
+			err = tx.Create(&ArticleSlugModel{Slug: model.Slug, ArticleID: model.ID}).Error
+		}
+		if err == nil {
+			err = tx.Model(model).Update(data).Error
+		}
+		// Update skips blank fields, but publishing or unscheduling clears PublishAt.
+		if err == nil && data.Status != "" {
+			err = tx.Model(model).Updates(map[string]interface{}{"status": data.Status, "publish_at": data.PublishAt}).Error
+		}
+		if err == nil && replace {
+			err = tx.Model(model).Updates(map[string]interface{}{"description": data.Description, "body": data.Body}).Error
+		}
+		if changed := before.changedFields(*model); err == nil && len(changed) != 0 {
+			err = addRevision(tx, *model, editorID, changed)
+		}
+		if err == nil {
+			err = tx.Commit().Error
+		} else {
+			tx.Rollback()
+			*model = before
+		}
+		if err = common.NormalizeDBError(err); !common.IsUniqueViolation(err) {
+			return err
+		}
+	}
 	return err
 }
 
+// The fields revisions keep which differ between the article and after, in their order.
+func (model ArticleModel) changedFields(after ArticleModel) []string {
+	var changed []string
+	if model.Title != after.Title {
+		changed = append(changed, "title")
+	}
+	if model.Description != after.Description {
+		changed = append(changed, "description")
+	}
+	if model.Body != after.Body {
+		changed = append(changed, "body")
+	}
+	return changed
+}
+
+// The first revision of a new article, however it was saved.
+func (model *ArticleModel) AfterCreate(tx *gorm.DB) error {
+	return addRevision(tx, *model, model.AuthorID, []string{"title", "description", "body"})
+}
+
+// Record the article as it is now as its next revision.
+func addRevision(tx *gorm.DB, model ArticleModel, editorID uint, changed []string) error {
+	var last uint
+	err := tx.Model(&ArticleRevisionModel{}).Where("article_id = ?", model.ID).Select("COALESCE(MAX(number), 0)").Row().Scan(&last)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&ArticleRevisionModel{
+		ArticleID:   model.ID,
+		Number:      last + 1,
+		Title:       model.Title,
+		Description: model.Description,
+		Body:        model.Body,
+		EditorID:    editorID,
+		Changed:     strings.Join(changed, ","),
+	}).Error
+}
+
+// Load a page of the article's revisions, newest first.
+func (self ArticleModel) getRevisions(page common.Page) ([]ArticleRevisionModel, int, common.Cursors, error) {
+	db := common.GetDB()
+	var models []ArticleRevisionModel
+	var count int
+	query := db.Model(&ArticleRevisionModel{}).Where("article_id = ?", self.ID)
+	query.Count(&count)
+	cursors, err := page.Find(query.Preload("Editor.UserModel"), &models, "", true)
+	return models, count, cursors, err
+}
+
+func (self ArticleModel) getRevision(number uint) (ArticleRevisionModel, error) {
+	db := common.GetDB()
+	var model ArticleRevisionModel
+	err := db.Where(ArticleRevisionModel{ArticleID: self.ID, Number: number}).Preload("Editor.UserModel").First(&model).Error
+	return model, err
+}
+
+// Paths under /api/articles/ which are not articles.
+var reservedSlugs = map[string]bool{"feed": true, "search": true}
+
+// The first of "base", "base-2", "base-3"... that no article other than the one with id
+// uses as its slug, now or in its history. Soft-deleted articles still hold theirs.
+func uniqueSlug(title string, id uint) (string, error) {
+	db := common.GetDB()
+	base := slug.Make(title)
+	if base == "" {
+		base = "article"
+	}
+	var taken, history []string
+	err := db.Unscoped().Model(&ArticleModel{}).Where("(slug = ? OR slug LIKE ?) AND id <> ?", base, base+"-%", id).Pluck("slug", &taken).Error
+	if err != nil {
+		return "", err
+	}
+	err = db.Model(&ArticleSlugModel{}).Where("(slug = ? OR slug LIKE ?) AND article_id <> ?", base, base+"-%", id).Pluck("slug", &history).Error
+	if err != nil {
+		return "", err
+	}
+	used := make(map[string]bool)
+	for s := range reservedSlugs {
+		used[s] = true
+	}
+	for _, s := range append(taken, history...) {
+		used[s] = true
+	}
+	candidate := base
+	for n := 2; used[candidate]; n++ {
+		candidate = fmt.Sprintf("%v-%v", base, n)
+	}
+	return candidate, nil
+}
+
 func DeleteArticleModel(condition interface{}) error {
 	db := common.GetDB()
-	err := db.Where(condition).Delete(ArticleModel{}).Error
-	return err
+	var ids []uint
+	tx := db.Begin()
+	err := tx.Model(&ArticleModel{}).Where(condition).Pluck("id", &ids).Error
+	if err == nil {
+		err = tx.Where(condition).Delete(ArticleModel{}).Error
+	}
+	if err == nil {
+		err = indexArticle(tx, ids...)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// A deleted article still in the trash, see common.ArticlesConfig.
+func FindOneTrashedArticle(slug string) (ArticleModel, error) {
+	db := common.GetDB()
+	var model ArticleModel
+	err := preloadArticles(db.Unscoped().Where("slug = ? AND deleted_at IS NOT NULL", slug)).First(&model).Error
+	return model, err
+}
+
+// Load a page of the articles the user deleted and which were not purged yet, the last
+// deleted first.
+func (self *ArticleUserModel) GetTrash(page common.Page) ([]ArticleModel, int, common.Cursors, error) {
+	db := common.GetDB()
+	var models []ArticleModel
+	var count int
+
+	tx := db.Begin()
+	query := tx.Unscoped().Model(&ArticleModel{}).Where("author_id = ? AND deleted_at IS NOT NULL", self.ID)
+	query.Count(&count)
+	cursors, err := page.Find(preloadArticles(query), &models, "DeletedAt", true)
+	if err != nil {
+		tx.Rollback()
+		return models, count, cursors, err
+	}
+	err = tx.Commit().Error
+	return models, count, cursors, err
+}
+
+// Load a page of the user's articles which are not published yet, drafts and scheduled
+// ones, newest first.
+func (self *ArticleUserModel) GetDrafts(page common.Page) ([]ArticleModel, int, common.Cursors, error) {
+	db := common.GetDB()
+	var models []ArticleModel
+	var count int
+
+	tx := db.Begin()
+	query := tx.Model(&ArticleModel{}).Where("author_id = ?", self.ID).Where("NOT "+publishedWhere(), time.Now())
+	query.Count(&count)
+	cursors, err := page.Find(preloadArticles(query), &models, "CreatedAt", true)
+	if err != nil {
+		tx.Rollback()
+		return models, count, cursors, err
+	}
+	err = tx.Commit().Error
+	return models, count, cursors, err
+}
+
+// Take the article out of the trash. It kept its slug, comments, favorites and tags.
+func (model *ArticleModel) Restore() error {
+	db := common.GetDB()
+	tx := db.Begin()
+	err := tx.Unscoped().Model(model).UpdateColumn("deleted_at", nil).Error
+	if err == nil {
+		err = indexArticle(tx, model.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	model.DeletedAt = nil
+	return tx.Commit().Error
+}
+
+// When an article deleted at deletedAt gets purged, see PurgeTrash.
+func PurgeAt(deletedAt time.Time) time.Time {
+	return deletedAt.Add(time.Duration(common.GetConfig().Articles.TrashRetention))
+}
+
+// Remove for good the articles which were in the trash for longer than
+// articles.trash_retention, along with everything hanging off them. Their slugs, current
+// and old, can be taken by new articles afterwards.
+func PurgeTrash() error {
+	db := common.GetDB()
+	cutoff := time.Now().Add(-time.Duration(common.GetConfig().Articles.TrashRetention))
+	var ids []uint
+	if err := db.Unscoped().Model(&ArticleModel{}).Where("deleted_at < ?", cutoff).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	tx := db.Begin()
+	err := tx.Where("comment_id IN (?)", tx.Unscoped().Model(&CommentModel{}).Select("id").Where("article_id IN (?)", ids).QueryExpr()).Delete(CommentEditModel{}).Error
+	if err == nil {
+		err = tx.Unscoped().Where("article_id IN (?)", ids).Delete(CommentModel{}).Error
+	}
+	if err == nil {
+		err = tx.Unscoped().Where("favorite_id IN (?)", ids).Delete(FavoriteModel{}).Error
+	}
+	if err == nil {
+		err = tx.Where("article_id IN (?)", ids).Delete(ReactionModel{}).Error
+	}
+	if err == nil {
+		err = tx.Where("article_id IN (?)", ids).Delete(BookmarkModel{}).Error
+	}
+	if err == nil {
+		err = tx.Exec("DELETE FROM article_tags WHERE article_model_id IN (?)", ids).Error
+	}
+	if err == nil {
+		err = tx.Where("article_id IN (?)", ids).Delete(ArticleSlugModel{}).Error
+	}
+	if err == nil {
+		err = tx.Where("article_id IN (?)", ids).Delete(ArticleRevisionModel{}).Error
+	}
+	if err == nil {
+		err = tx.Where("notification_id IN (?)", tx.Model(&users.NotificationModel{}).Select("id").Where("article_id IN (?)", ids).QueryExpr()).Delete(users.NotificationActorModel{}).Error
+	}
+	if err == nil {
+		err = tx.Where("article_id IN (?)", ids).Delete(users.NotificationModel{}).Error
+	}
+	if err == nil {
+		err = indexArticle(tx, ids...)
+	}
+	if err == nil {
+		err = tx.Unscoped().Where("id IN (?)", ids).Delete(ArticleModel{}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
 
+// Delete comments. One which still has replies stays as a blank placeholder so the thread
+// holds together, and goes with the last of them.
 func DeleteCommentModel(condition interface{}) error {
 	db := common.GetDB()
-	err := db.Where(condition).Delete(CommentModel{}).Error
-	return err
+	var articleIDs []uint
+	tx := db.Begin()
+	err := tx.Model(&CommentModel{}).Where(condition).Pluck("DISTINCT article_id", &articleIDs).Error
+	hasReplies := "EXISTS (SELECT 1 FROM comment_models r WHERE r.parent_id = comment_models.id AND r.deleted_at IS NULL)"
+	if err == nil {
+		err = tx.Model(&CommentModel{}).Where(condition).Where(hasReplies).UpdateColumns(map[string]interface{}{"body": "", "removed": true}).Error
+	}
+	if err == nil {
+		err = tx.Where(condition).Where("NOT " + hasReplies).Delete(CommentModel{}).Error
+	}
+	// Placeholders left without replies, up the thread as far as it goes.
+	for deleted := int64(1); err == nil && deleted > 0; {
+		result := tx.Where("removed = ? AND article_id IN (?)", true, articleIDs).Where("NOT " + hasReplies).Delete(CommentModel{})
+		err, deleted = result.Error, result.RowsAffected
+	}
+	// The edit history holds the old bodies, it goes with the comments and the placeholders.
+	if err == nil {
+		gone := tx.Unscoped().Model(&CommentModel{}).Select("id").Where("article_id IN (?) AND (removed = ? OR deleted_at IS NOT NULL)", articleIDs, true)
+		err = tx.Where("comment_id IN (?)", gone.QueryExpr()).Delete(CommentEditModel{}).Error
+	}
+	if err == nil {
+		err = indexArticle(tx, articleIDs...)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
@@ -2,27 +2,54 @@ package articles
 
 import (
 	"errors"
+	"fmt"
 	"realworld-backend/common"
 	"realworld-backend/users"
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"log"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
 )
 
 func ArticlesRegister(router *gin.RouterGroup) {
 	router.POST("/", ArticleCreate)
 	router.PUT("/:slug", ArticleUpdate)
 	router.DELETE("/:slug", ArticleDelete)
+	router.POST("/:slug/restore", ArticleRestore)
+	router.POST("/:slug/revert/:n", ArticleRevert)
 	router.POST("/:slug/favorite", ArticleFavorite)
 	router.DELETE("/:slug/favorite", ArticleUnfavorite)
+	router.POST("/:slug/reactions/:reaction", ArticleReact)
+	router.DELETE("/:slug/reactions/:reaction", ArticleUnreact)
+	router.POST("/:slug/bookmark", ArticleBookmark)
+	router.DELETE("/:slug/bookmark", ArticleUnbookmark)
 	router.POST("/:slug/comments", ArticleCommentCreate)
+	router.PUT("/:slug/comments/:id", ArticleCommentUpdate)
 	router.DELETE("/:slug/comments/:id", ArticleCommentDelete)
+	router.GET("/:slug/comments/:id/edits", ArticleCommentEdits)
 }
 
 func ArticlesAnonymousRegister(router *gin.RouterGroup) {
 	router.GET("/", ArticleList)
+	router.GET("/search", ArticleSearch)
 	router.GET("/:slug", ArticleRetrieve)
 	router.GET("/:slug/comments", ArticleCommentList)
+	router.GET("/:slug/comments/stream", ArticleCommentStream)
+	router.GET("/:slug/reactions/:reaction", ArticleReactionList)
+	router.GET("/:slug/revisions", ArticleRevisionList)
+	router.GET("/:slug/revisions/:n", ArticleRevisionRetrieve)
+	router.GET("/:slug/revisions/:n/diff", ArticleRevisionDiff)
+}
+
+// Registered on /api/user, next to users.UserRegister.
+func UserArticlesRegister(router *gin.RouterGroup) {
+	router.GET("/drafts", ArticleDrafts)
+	router.GET("/trash", ArticleTrash)
+	router.GET("/bookmarks", ArticleBookmarks)
+	router.GET("/bookmarks/folders", ArticleBookmarkFolders)
 }
 
 func TagsAnonymousRegister(router *gin.RouterGroup) {
@@ -37,7 +64,7 @@ func ArticleCreate(c *gin.Context) {
 	}
 	//fmt.Println(articleModelValidator.articleModel.Author.UserModel)
 
-	if err := SaveOne(&articleModelValidator.articleModel); err != nil {
+	if err := CreateArticle(&articleModelValidator.articleModel); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
@@ -46,37 +73,77 @@ func ArticleCreate(c *gin.Context) {
 }
 
 func ArticleList(c *gin.Context) {
-	//condition := ArticleModel{}
-	tag := c.Query("tag")
-	author := c.Query("author")
-	favorited := c.Query("favorited")
-	limit := c.Query("limit")
-	offset := c.Query("offset")
-	articleModels, modelCount, err := FindManyArticle(tag, author, limit, offset, favorited)
+	articleFilterValidator := NewArticleFilterValidator()
+	if err := articleFilterValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	page, key, err := common.ParsePage(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
+	articleFilterValidator.filter.Viewer = c.MustGet("my_user_model").(users.UserModel)
+	articleModels, modelCount, cursors, err := FindManyArticle(articleFilterValidator.filter, page)
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
 		return
 	}
 	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount, "nextCursor": cursors.Next, "prevCursor": cursors.Prev})
+}
+
+func ArticleSearch(c *gin.Context) {
+	articleSearchValidator := NewArticleSearchValidator()
+	if err := articleSearchValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	if len(articleSearchValidator.terms) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("q", errors.New("has no words to search for")))
+		return
+	}
+	page, key, err := common.ParsePage(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
+	if page.After != nil || page.Before != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("cursor", errors.New("search results are ranked, page them with offset")))
+		return
+	}
+	articleModels, hits, modelCount, err := SearchArticles(articleSearchValidator.terms, c.MustGet("my_user_model").(users.UserModel), page)
+	if errors.Is(err, errSearchUnsupported) {
+		c.JSON(http.StatusNotImplemented, common.NewError("search", err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
+		return
+	}
+	serializer := SearchResultsSerializer{c, articleModels, hits}
 	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount})
 }
 
 func ArticleFeed(c *gin.Context) {
-	limit := c.Query("limit")
-	offset := c.Query("offset")
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
 	if myUserModel.ID == 0 {
 		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
 		return
 	}
+	page, key, err := common.ParsePage(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
 	articleUserModel := GetArticleUserModel(myUserModel)
-	articleModels, modelCount, err := articleUserModel.GetArticleFeed(limit, offset)
+	articleModels, modelCount, cursors, err := articleUserModel.GetArticleFeed(page)
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
 		return
 	}
 	serializer := ArticlesSerializer{c, articleModels}
-	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount})
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount, "nextCursor": cursors.Next, "prevCursor": cursors.Prev})
 }
 
 func ArticleRetrieve(c *gin.Context) {
@@ -85,13 +152,30 @@ func ArticleRetrieve(c *gin.Context) {
 		ArticleFeed(c)
 		return
 	}
+	if format := c.Query("format"); format != "" && format != "markdown" && format != "html" {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("format", errors.New("must be markdown or html")))
+		return
+	}
 	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
-	if err != nil {
+	if err == nil && canSee(c, articleModel) {
+		serializer := ArticleSerializer{c, articleModel}
+		c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+		return
+	}
+	if err == nil {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
 	}
+	// An old slug redirects to the current one, the body has the article for clients
+	// which don't follow redirects.
+	articleModel, err = FindOneArticleBySlugHistory(slug)
+	if err != nil || !canSee(c, articleModel) {
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		return
+	}
+	c.Header("Location", path.Join(path.Dir(c.Request.URL.Path), articleModel.Slug))
 	serializer := ArticleSerializer{c, articleModel}
-	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+	c.JSON(http.StatusMovedPermanently, gin.H{"article": serializer.Response()})
 }
 
 func ArticleUpdate(c *gin.Context) {
@@ -101,6 +185,9 @@ func ArticleUpdate(c *gin.Context) {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
 	}
+	if !RequireOwner(c, "article", articleModel) {
+		return
+	}
 	articleModelValidator := NewArticleModelValidatorFillWith(articleModel)
 	if err := articleModelValidator.Bind(c); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
@@ -118,7 +205,15 @@ func ArticleUpdate(c *gin.Context) {
 
 func ArticleDelete(c *gin.Context) {
 	slug := c.Param("slug")
-	err := DeleteArticleModel(&ArticleModel{Slug: slug})
+	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		return
+	}
+	if !RequireOwner(c, "article", articleModel) {
+		return
+	}
+	err = DeleteArticleModel(&ArticleModel{Slug: slug})
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
@@ -126,15 +221,101 @@ func ArticleDelete(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"article": "Delete success"})
 }
 
+func ArticleDrafts(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+		return
+	}
+	page, key, err := common.ParsePage(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
+	articleUserModel := GetArticleUserModel(myUserModel)
+	articleModels, modelCount, cursors, err := articleUserModel.GetDrafts(page)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount, "nextCursor": cursors.Next, "prevCursor": cursors.Prev})
+}
+
+func ArticleTrash(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+		return
+	}
+	page, key, err := common.ParsePage(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
+	articleUserModel := GetArticleUserModel(myUserModel)
+	articleModels, modelCount, cursors, err := articleUserModel.GetTrash(page)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
+		return
+	}
+	serializer := TrashSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount, "nextCursor": cursors.Next, "prevCursor": cursors.Prev})
+}
+
+func ArticleRestore(c *gin.Context) {
+	slug := c.Param("slug")
+	articleModel, err := FindOneTrashedArticle(slug)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		return
+	}
+	if !RequireOwner(c, "article", articleModel) {
+		return
+	}
+	if err := articleModel.Restore(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+}
+
+// Tell the author what the user did to the article. The user did it anyway, so a failing
+// notification is only logged.
+func notifyAuthor(article ArticleModel, kind string, actor users.UserModel) {
+	notification := users.NotificationModel{
+		UserID:       article.Author.UserModelID,
+		Kind:         kind,
+		ArticleID:    article.ID,
+		ArticleSlug:  article.Slug,
+		ArticleTitle: article.Title,
+	}
+	if err := users.Notify(notification, actor); err != nil {
+		log.Println("notify:", err)
+	}
+}
+
 func ArticleFavorite(c *gin.Context) {
 	slug := c.Param("slug")
 	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
-	if err != nil {
+	if err != nil || !canSee(c, articleModel) {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
 	}
+	if blockedByAuthor(c, "articles", articleModel) {
+		return
+	}
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-	err = articleModel.favoriteBy(GetArticleUserModel(myUserModel))
+	articleUserModel := GetArticleUserModel(myUserModel)
+	favorited := favoritedBy([]uint{articleModel.ID}, articleUserModel)[articleModel.ID]
+	if err := articleModel.favoriteBy(articleUserModel); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	if !favorited {
+		notifyAuthor(articleModel, users.NotifyFavorite, myUserModel)
+	}
 	serializer := ArticleSerializer{c, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
@@ -142,48 +323,274 @@ func ArticleFavorite(c *gin.Context) {
 func ArticleUnfavorite(c *gin.Context) {
 	slug := c.Param("slug")
 	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	if err != nil || !canSee(c, articleModel) {
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if err := articleModel.unFavoriteBy(GetArticleUserModel(myUserModel)); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+}
+
+func ArticleBookmark(c *gin.Context) {
+	articleModel, err := FindOneArticle(&ArticleModel{Slug: c.Param("slug")})
+	if err != nil || !canSee(c, articleModel) {
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		return
+	}
+	var bookmarkValidator BookmarkValidator
+	if err := bookmarkValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if err := articleModel.bookmarkBy(myUserModel, bookmarkValidator.Bookmark.Folder); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+}
+
+func ArticleUnbookmark(c *gin.Context) {
+	articleModel, err := FindOneArticle(&ArticleModel{Slug: c.Param("slug")})
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-	err = articleModel.unFavoriteBy(GetArticleUserModel(myUserModel))
+	if err := articleModel.unbookmarkBy(myUserModel); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
 	serializer := ArticleSerializer{c, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
+// The current user's bookmarks, ?folder= for one folder only (empty for those in none).
+func ArticleBookmarks(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+		return
+	}
+	page, key, err := common.ParsePage(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
+	var folder *string
+	if value, ok := c.GetQuery("folder"); ok {
+		value = strings.TrimSpace(value)
+		folder = &value
+	}
+	articleModels, modelCount, cursors, err := GetBookmarks(myUserModel, folder, page)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount, "nextCursor": cursors.Next, "prevCursor": cursors.Prev})
+}
+
+func ArticleBookmarkFolders(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+		return
+	}
+	folders, err := GetBookmarkFolders(myUserModel)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("folders", errors.New("Database error")))
+		return
+	}
+	response := []BookmarkFolderResponse{}
+	for _, folder := range folders {
+		response = append(response, BookmarkFolderResponse{folder.Folder, folder.Count})
+	}
+	c.JSON(http.StatusOK, gin.H{"folders": response})
+}
+
+// The visible article of the slug and the configured :reaction, or false once the 404 is
+// written.
+func findReaction(c *gin.Context) (ArticleModel, string, bool) {
+	articleModel, err := FindOneArticle(&ArticleModel{Slug: c.Param("slug")})
+	if err != nil || !canSee(c, articleModel) {
+		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		return articleModel, "", false
+	}
+	reaction, ok := findReactionConfig(c.Param("reaction"))
+	if !ok {
+		c.JSON(http.StatusNotFound, common.NewError("reaction", errors.New("Invalid reaction")))
+		return articleModel, "", false
+	}
+	return articleModel, reaction.Name, true
+}
+
+func ArticleReact(c *gin.Context) {
+	articleModel, reaction, ok := findReaction(c)
+	if !ok {
+		return
+	}
+	if blockedByAuthor(c, "reaction", articleModel) {
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if err := articleModel.reactBy(GetArticleUserModel(myUserModel), reaction); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+}
+
+func ArticleUnreact(c *gin.Context) {
+	articleModel, reaction, ok := findReaction(c)
+	if !ok {
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if err := articleModel.unreactBy(GetArticleUserModel(myUserModel), reaction); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+}
+
+func ArticleReactionList(c *gin.Context) {
+	articleModel, reaction, ok := findReaction(c)
+	if !ok {
+		return
+	}
+	page, key, err := common.ParsePage(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
+	reactionModels, modelCount, cursors, err := articleModel.getReactions(reaction, page)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("reactions", errors.New("Database error")))
+		return
+	}
+	serializer := ReactionsSerializer{c, reactionModels}
+	c.JSON(http.StatusOK, gin.H{"profiles": serializer.Response(), "profilesCount": modelCount, "nextCursor": cursors.Next, "prevCursor": cursors.Prev})
+}
+
 func ArticleCommentCreate(c *gin.Context) {
 	slug := c.Param("slug")
 	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
-	if err != nil {
+	if err != nil || !canSee(c, articleModel) {
 		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid slug")))
 		return
 	}
+	if blockedByAuthor(c, "comment", articleModel) {
+		return
+	}
 	commentModelValidator := NewCommentModelValidator()
 	if err := commentModelValidator.Bind(c); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
 		return
 	}
 	commentModelValidator.commentModel.Article = articleModel
+	if parentID := commentModelValidator.Comment.ParentID; parentID != nil {
+		parent, err := FindOneComment(&CommentModel{Model: gorm.Model{ID: *parentID}, ArticleID: articleModel.ID})
+		if err != nil || parent.Removed {
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("parentId", errors.New("is not a comment of this article")))
+			return
+		}
+		if parent.Depth+1 >= maxCommentDepth {
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("parentId", fmt.Errorf("replies can't nest more than %v deep", maxCommentDepth)))
+			return
+		}
+		commentModelValidator.commentModel.ParentID = parentID
+		commentModelValidator.commentModel.Depth = parent.Depth + 1
+	}
 
 	if err := SaveOne(&commentModelValidator.commentModel); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
+	notifyAuthor(articleModel, users.NotifyComment, c.MustGet("my_user_model").(users.UserModel))
+	common.GetHub().Publish(commentsTopic(articleModel.ID), "comment", commentModelValidator.commentModel)
 	serializer := CommentSerializer{c, commentModelValidator.commentModel}
 	c.JSON(http.StatusCreated, gin.H{"comment": serializer.Response()})
 }
 
-func ArticleCommentDelete(c *gin.Context) {
-	id64, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	id := uint(id64)
+// The visible article of the slug and its comment :id, or false once the 404 is written.
+// Placeholders of deleted comments are not found.
+func findComment(c *gin.Context) (ArticleModel, CommentModel, bool) {
+	articleModel, err := FindOneArticle(&ArticleModel{Slug: c.Param("slug")})
+	if err != nil || !canSee(c, articleModel) {
+		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid slug")))
+		return articleModel, CommentModel{}, false
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid id")))
+		return articleModel, CommentModel{}, false
+	}
+	commentModel, err := FindOneComment(&CommentModel{Model: gorm.Model{ID: uint(id)}, ArticleID: articleModel.ID})
+	if err != nil || commentModel.Removed {
+		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid id")))
+		return articleModel, CommentModel{}, false
+	}
+	return articleModel, commentModel, true
+}
+
+func ArticleCommentUpdate(c *gin.Context) {
+	_, commentModel, ok := findComment(c)
+	if !ok || !RequireOwner(c, "comment", commentModel) {
+		return
+	}
+	commentModelValidator := NewCommentModelValidator()
+	if err := commentModelValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	if err := commentModel.Update(commentModelValidator.Comment.Body, commentModelValidator.commentModel.Author); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
-	err = DeleteCommentModel([]uint{id})
+	commentModel, _ = FindOneComment(&CommentModel{Model: gorm.Model{ID: commentModel.ID}})
+	serializer := CommentSerializer{c, commentModel}
+	c.JSON(http.StatusOK, gin.H{"comment": serializer.Response()})
+}
+
+// The edit history of a comment, for its author and the moderators.
+func ArticleCommentEdits(c *gin.Context) {
+	_, commentModel, ok := findComment(c)
+	if !ok {
+		return
+	}
+	if !isModerator(c) && !RequireOwner(c, "comment", commentModel) {
+		return
+	}
+	page, key, err := common.ParsePage(c)
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid id")))
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
+	editModels, modelCount, cursors, err := commentModel.getEdits(page)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("edits", errors.New("Database error")))
+		return
+	}
+	serializer := CommentEditsSerializer{c, editModels}
+	c.JSON(http.StatusOK, gin.H{"edits": serializer.Response(), "editsCount": modelCount, "nextCursor": cursors.Next, "prevCursor": cursors.Prev})
+}
+
+func ArticleCommentDelete(c *gin.Context) {
+	_, commentModel, ok := findComment(c)
+	if !ok || !RequireOwner(c, "comment", commentModel) {
+		return
+	}
+	if err := DeleteCommentModel([]uint{commentModel.ID}); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"comment": "Delete success"})
@@ -192,18 +599,139 @@ func ArticleCommentDelete(c *gin.Context) {
 func ArticleCommentList(c *gin.Context) {
 	slug := c.Param("slug")
 	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
-	if err != nil {
+	if err != nil || !canSee(c, articleModel) {
 		c.JSON(http.StatusNotFound, common.NewError("comments", errors.New("Invalid slug")))
 		return
 	}
-	err = articleModel.getComments()
+	page, key, err := common.ParsePage(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
+	if !common.Paged(c) {
+		page.Limit = 0 // all of them, as the spec has it
+	}
+	cursors, err := articleModel.getComments(page, c.MustGet("my_user_model").(users.UserModel))
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("comments", errors.New("Database error")))
 		return
 	}
 	serializer := CommentsSerializer{c, articleModel.Comments}
-	c.JSON(http.StatusOK, gin.H{"comments": serializer.Response()})
+	c.JSON(http.StatusOK, gin.H{"comments": serializer.Response(), "nextCursor": cursors.Next, "prevCursor": cursors.Prev})
+}
+
+// Server-sent events of the new comments of the article, without those of users the
+// current user blocked or muted.
+func ArticleCommentStream(c *gin.Context) {
+	slug := c.Param("slug")
+	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	if err != nil || !canSee(c, articleModel) {
+		c.JSON(http.StatusNotFound, common.NewError("comments", errors.New("Invalid slug")))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	common.Stream(c, commentsTopic(articleModel.ID), func(event common.Event) (interface{}, bool) {
+		commentModel := event.Data.(CommentModel)
+		if myUserModel.ID != 0 && myUserModel.Hides(commentModel.Author.UserModel) {
+			return nil, false
+		}
+		serializer := CommentSerializer{c, commentModel}
+		return serializer.Response(), true
+	})
+}
+
+func ArticleRevisionList(c *gin.Context) {
+	slug := c.Param("slug")
+	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	if err != nil || !canSee(c, articleModel) {
+		c.JSON(http.StatusNotFound, common.NewError("revisions", errors.New("Invalid slug")))
+		return
+	}
+	page, key, err := common.ParsePage(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
+	revisionModels, modelCount, cursors, err := articleModel.getRevisions(page)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("revisions", errors.New("Database error")))
+		return
+	}
+	serializer := RevisionsSerializer{c, revisionModels}
+	c.JSON(http.StatusOK, gin.H{"revisions": serializer.Response(), "revisionsCount": modelCount, "nextCursor": cursors.Next, "prevCursor": cursors.Prev})
+}
+
+// The visible article of the slug and its revision number n, or false once the 404 is written.
+func findRevision(c *gin.Context) (ArticleModel, ArticleRevisionModel, bool) {
+	articleModel, err := FindOneArticle(&ArticleModel{Slug: c.Param("slug")})
+	if err != nil || !canSee(c, articleModel) {
+		c.JSON(http.StatusNotFound, common.NewError("revision", errors.New("Invalid slug")))
+		return articleModel, ArticleRevisionModel{}, false
+	}
+	n, err := strconv.ParseUint(c.Param("n"), 10, 32)
+	var revisionModel ArticleRevisionModel
+	if err == nil {
+		revisionModel, err = articleModel.getRevision(uint(n))
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("revision", errors.New("Invalid number")))
+		return articleModel, revisionModel, false
+	}
+	return articleModel, revisionModel, true
+}
+
+func ArticleRevisionRetrieve(c *gin.Context) {
+	_, revisionModel, ok := findRevision(c)
+	if !ok {
+		return
+	}
+	serializer := RevisionSerializer{c, revisionModel}
+	c.JSON(http.StatusOK, gin.H{"revision": serializer.Response()})
+}
+
+// ?from=m compares revision m with n instead of the one before n, from=0 is the empty article.
+func ArticleRevisionDiff(c *gin.Context) {
+	articleModel, revisionModel, ok := findRevision(c)
+	if !ok {
+		return
+	}
+	from := uint64(revisionModel.Number - 1)
+	if value := c.Query("from"); value != "" {
+		var err error
+		if from, err = strconv.ParseUint(value, 10, 32); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("from", errors.New("should be a revision number")))
+			return
+		}
+	}
+	var fromModel ArticleRevisionModel
+	if from != 0 {
+		var err error
+		if fromModel, err = articleModel.getRevision(uint(from)); err != nil {
+			c.JSON(http.StatusNotFound, common.NewError("from", errors.New("Invalid number")))
+			return
+		}
+	}
+	serializer := RevisionDiffSerializer{c, fromModel, revisionModel}
+	c.JSON(http.StatusOK, gin.H{"diff": serializer.Response()})
+}
+
+func ArticleRevert(c *gin.Context) {
+	articleModel, revisionModel, ok := findRevision(c)
+	if !ok {
+		return
+	}
+	if !RequireOwner(c, "article", articleModel) {
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if err := articleModel.Revert(revisionModel, GetArticleUserModel(myUserModel)); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
+
 func TagList(c *gin.Context) {
 	tagModels, err := getAllTags()
 	if err != nil {
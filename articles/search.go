@@ -0,0 +1,347 @@
+package articles
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jinzhu/gorm"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+// The full-text index behind /api/articles/search, one row per article with its title,
+// description, body, tags and comments. SQLite keeps it in an FTS5 table, or FTS4 when the
+// driver is built without FTS5 (go-sqlite3 needs `-tags sqlite_fts5`), Postgres in a
+// weighted tsvector. The row is rewritten whenever the article or one of its comments is
+// saved or deleted, see indexArticle.
+const searchTable = "article_search"
+
+var errSearchUnsupported = errors.New("search is not supported on this database")
+
+// How much a match in each indexed column counts: title, description, body, tags, comments.
+var searchWeights = []float64{10, 2, 1, 5, 0.5}
+
+// Snippets are marked with control characters, which can't be in the text, and only turned
+// into <mark> once the text around them is escaped.
+const (
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+// A search result besides the article itself: the title and a piece of the text with the
+// matching words in <mark>.
+type SearchHit struct {
+	ArticleID uint
+	Title     string
+	Snippet   string
+}
+
+// Create the index and fill it with the existing articles, unless it is already there.
+func createSearchIndex(db *gorm.DB) error {
+	if db.HasTable(searchTable) {
+		return nil
+	}
+	var statements []string
+	switch db.Dialect().GetName() {
+	case "sqlite3":
+		module := "fts5(title, description, body, tags, comments, tokenize = 'porter unicode61')"
+		var fts5 int
+		db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Row().Scan(&fts5)
+		if fts5 == 0 {
+			module = "fts4(title, description, body, tags, comments, tokenize=porter)"
+		}
+		statements = []string{"CREATE VIRTUAL TABLE article_search USING " + module}
+	case "postgres":
+		statements = []string{
+			"CREATE TABLE article_search (article_id integer PRIMARY KEY, document tsvector NOT NULL)",
+			"CREATE INDEX idx_article_search_document ON article_search USING GIN (document)",
+		}
+	default:
+		return nil
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return db.Exec(searchDocuments(db, "")).Error
+}
+
+// The INSERT writing the index rows of the articles matching where, all of them when it is "".
+func searchDocuments(db *gorm.DB, where string) string {
+	if where != "" {
+		where = "AND " + where
+	}
+	if db.Dialect().GetName() == "postgres" {
+		return `INSERT INTO article_search (article_id, document)
+SELECT a.id,
+	setweight(to_tsvector('english', a.title), 'A') ||
+	setweight(to_tsvector('english', COALESCE((SELECT string_agg(t.tag, ' ') FROM article_tags x JOIN tag_models t ON t.id = x.tag_model_id WHERE x.article_model_id = a.id), '')), 'B') ||
+	setweight(to_tsvector('english', a.description), 'C') ||
+	setweight(to_tsvector('english', a.body || ' ' || COALESCE((SELECT string_agg(c.body, ' ') FROM comment_models c WHERE c.article_id = a.id AND c.deleted_at IS NULL), '')), 'D')
+FROM article_models a WHERE a.deleted_at IS NULL ` + where
+	}
+	return `INSERT INTO article_search (rowid, title, description, body, tags, comments)
+SELECT a.id, a.title, a.description, a.body,
+	COALESCE((SELECT group_concat(t.tag, ' ') FROM article_tags x JOIN tag_models t ON t.id = x.tag_model_id WHERE x.article_model_id = a.id), ''),
+	COALESCE((SELECT group_concat(c.body, ' ') FROM comment_models c WHERE c.article_id = a.id AND c.deleted_at IS NULL), '')
+FROM article_models a WHERE a.deleted_at IS NULL ` + where
+}
+
+func searchKey(db *gorm.DB) string {
+	if db.Dialect().GetName() == "postgres" {
+		return "article_id"
+	}
+	return "rowid"
+}
+
+func searchSupported(db *gorm.DB) bool {
+	name := db.Dialect().GetName()
+	return name == "sqlite3" || name == "postgres"
+}
+
+// Rewrite the index rows of the articles from what is in the database now, a deleted
+// article just loses its row. tx should be the transaction that changed them.
+func indexArticle(tx *gorm.DB, ids ...uint) error {
+	if len(ids) == 0 || !searchSupported(tx) {
+		return nil
+	}
+	err := tx.Exec(fmt.Sprintf("DELETE FROM article_search WHERE %v IN (?)", searchKey(tx)), ids).Error
+	if err == nil {
+		err = tx.Exec(searchDocuments(tx, "a.id IN (?)"), ids).Error
+	}
+	return err
+}
+
+// Keep the index in step with the article, whichever way it got saved.
+func (model *ArticleModel) AfterSave(tx *gorm.DB) error {
+	return indexArticle(tx, model.ID)
+}
+
+// Comments are searched as part of their article.
+func (model *CommentModel) AfterSave(tx *gorm.DB) error {
+	return indexArticle(tx, model.ArticleID)
+}
+
+// Split a search query into the words to look for, lower case and without punctuation,
+// so nothing a user types is taken for query syntax.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// The articles having every one of the terms, best match first, with their hits in the
+// same order. Only offset pages make sense for a ranking. See searchVisible for the
+// articles the viewer doesn't find.
+func SearchArticles(terms []string, viewer users.UserModel, page common.Page) ([]ArticleModel, []SearchHit, int, error) {
+	db := common.GetDB()
+	var models []ArticleModel
+	var hits []SearchHit
+	var count int
+	var err error
+	switch {
+	case !searchSupported(db):
+		return models, hits, count, errSearchUnsupported
+	case db.Dialect().GetName() == "postgres":
+		hits, count, err = searchPostgres(db, terms, viewer, page)
+	case strings.Contains(searchModule(db), "fts5"):
+		hits, count, err = searchFTS5(db, terms, viewer, page)
+	default:
+		hits, count, err = searchFTS4(db, terms, viewer, page)
+	}
+	if err != nil || len(hits) == 0 {
+		return models, hits, count, err
+	}
+	var ids []uint
+	for _, hit := range hits {
+		ids = append(ids, hit.ArticleID)
+	}
+	var found []ArticleModel
+	if err := preloadArticles(db.Where("id IN (?)", ids)).Find(&found).Error; err != nil {
+		return models, hits, count, err
+	}
+	byID := make(map[uint]ArticleModel)
+	for _, model := range found {
+		byID[model.ID] = model
+	}
+	var kept []SearchHit
+	for _, hit := range hits {
+		if model, ok := byID[hit.ArticleID]; ok {
+			models = append(models, model)
+			kept = append(kept, hit)
+		}
+	}
+	return models, kept, count, nil
+}
+
+// The matches the viewer gets: none of private authors they don't follow, nor of users they
+// blocked or muted. Every backend adds it to its WHERE, so counts and pages only have those.
+func searchVisible(db *gorm.DB, viewer users.UserModel) (string, []interface{}) {
+	return " AND article_models.author_id NOT IN ? AND article_models.author_id NOT IN ?",
+		[]interface{}{privateAuthors(db, viewer).SubQuery(), hiddenAuthors(db, viewer).SubQuery()}
+}
+
+// How the SQLite index was created, FTS5 or FTS4.
+func searchModule(db *gorm.DB) string {
+	var sql string
+	db.Raw("SELECT sql FROM sqlite_master WHERE name = ?", searchTable).Row().Scan(&sql)
+	return strings.ToLower(sql)
+}
+
+// Every term has to match, quoted so it is a plain word to FTS.
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Matches of published articles only, the arguments are the match and the time. The
+// searchVisible condition goes right after it.
+var ftsFrom = ` FROM article_search
+JOIN article_models ON article_models.id = article_search.rowid AND article_models.deleted_at IS NULL
+WHERE article_search MATCH ? AND ` + publishedWhere()
+
+func searchFTS5(db *gorm.DB, terms []string, viewer users.UserModel, page common.Page) ([]SearchHit, int, error) {
+	var count int
+	visible, visibleArgs := searchVisible(db, viewer)
+	from, where := ftsFrom+visible, append([]interface{}{ftsQuery(terms), time.Now()}, visibleArgs...)
+	if err := db.Raw("SELECT count(*)"+from, where...).Row().Scan(&count); err != nil {
+		return nil, 0, err
+	}
+	weights := make([]string, len(searchWeights))
+	for i, weight := range searchWeights {
+		weights[i] = fmt.Sprint(weight)
+	}
+	args := append([]interface{}{snippetStart, snippetEnd, snippetStart, snippetEnd}, where...)
+	rows, err := db.Raw(`SELECT article_search.rowid, highlight(article_search, 0, ?, ?), snippet(article_search, -1, ?, ?, '…', 16)`+from+
+		` ORDER BY bm25(article_search, `+strings.Join(weights, ", ")+`), article_search.rowid DESC LIMIT ? OFFSET ?`,
+		append(args, page.Limit, page.Offset)...).Rows()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var hits []SearchHit
+	for rows.Next() {
+		var hit SearchHit
+		if err := rows.Scan(&hit.ArticleID, &hit.Title, &hit.Snippet); err != nil {
+			return nil, 0, err
+		}
+		hits = append(hits, hit.marked())
+	}
+	return hits, count, rows.Err()
+}
+
+// FTS4 has no ranking function, so every match is scored here from matchinfo, the way the
+// SQLite documentation ranks FTS4 results: for each term and column, the share of all its
+// hits that are in this row, times the column's weight.
+func searchFTS4(db *gorm.DB, terms []string, viewer users.UserModel, page common.Page) ([]SearchHit, int, error) {
+	visible, visibleArgs := searchVisible(db, viewer)
+	args := append([]interface{}{snippetStart, snippetEnd, snippetStart, snippetEnd, ftsQuery(terms), time.Now()}, visibleArgs...)
+	rows, err := db.Raw(`SELECT article_search.rowid, snippet(article_search, ?, ?, '…', 0, 64), snippet(article_search, ?, ?, '…', -1, 16), matchinfo(article_search, 'pcx')`+ftsFrom+visible,
+		args...).Rows()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var hits []SearchHit
+	scores := make(map[uint]float64)
+	for rows.Next() {
+		var hit SearchHit
+		var matchinfo []byte
+		if err := rows.Scan(&hit.ArticleID, &hit.Title, &hit.Snippet, &matchinfo); err != nil {
+			return nil, 0, err
+		}
+		scores[hit.ArticleID] = fts4Score(matchinfo)
+		hits = append(hits, hit.marked())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if scores[hits[i].ArticleID] != scores[hits[j].ArticleID] {
+			return scores[hits[i].ArticleID] > scores[hits[j].ArticleID]
+		}
+		return hits[i].ArticleID > hits[j].ArticleID
+	})
+	count := len(hits)
+	if page.Offset >= count {
+		return nil, count, nil
+	}
+	hits = hits[page.Offset:]
+	if len(hits) > page.Limit {
+		hits = hits[:page.Limit]
+	}
+	return hits, count, nil
+}
+
+// matchinfo 'pcx' is native-endian uint32s: the number of phrases, of columns, then for
+// every phrase and column its hits in this row, in all rows, and the rows it is in.
+func fts4Score(matchinfo []byte) float64 {
+	values := make([]uint32, len(matchinfo)/4)
+	for i := range values {
+		values[i] = binary.NativeEndian.Uint32(matchinfo[i*4:])
+	}
+	if len(values) < 2 {
+		return 0
+	}
+	phrases, columns := int(values[0]), int(values[1])
+	var score float64
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < columns && c < len(searchWeights); c++ {
+			i := 2 + 3*(p*columns+c)
+			if i+1 < len(values) && values[i+1] > 0 {
+				score += searchWeights[c] * float64(values[i]) / float64(values[i+1])
+			}
+		}
+	}
+	return score
+}
+
+func searchPostgres(db *gorm.DB, terms []string, viewer users.UserModel, page common.Page) ([]SearchHit, int, error) {
+	visible, visibleArgs := searchVisible(db, viewer)
+	from := ` FROM article_search
+JOIN article_models ON article_models.id = article_search.article_id AND article_models.deleted_at IS NULL,
+plainto_tsquery('english', ?) query
+WHERE article_search.document @@ query AND ` + publishedWhere() + visible
+	var count int
+	where := append([]interface{}{strings.Join(terms, " "), time.Now()}, visibleArgs...)
+	if err := db.Raw("SELECT count(*)"+from, where...).Row().Scan(&count); err != nil {
+		return nil, 0, err
+	}
+	marks := fmt.Sprintf("StartSel=%v, StopSel=%v", snippetStart, snippetEnd)
+	rows, err := db.Raw(`SELECT article_search.article_id,
+	ts_headline('english', article_models.title, query, ?),
+	ts_headline('english', article_models.description || ' ' || article_models.body, query, ?)`+from+
+		` ORDER BY ts_rank(article_search.document, query) DESC, article_search.article_id DESC LIMIT ? OFFSET ?`,
+		append(append([]interface{}{marks + ", HighlightAll=true", marks + ", MinWords=8, MaxWords=24"}, where...), page.Limit, page.Offset)...).Rows()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var hits []SearchHit
+	for rows.Next() {
+		var hit SearchHit
+		if err := rows.Scan(&hit.ArticleID, &hit.Title, &hit.Snippet); err != nil {
+			return nil, 0, err
+		}
+		hits = append(hits, hit.marked())
+	}
+	return hits, count, rows.Err()
+}
+
+// Escape the title and snippet for HTML and only then mark the matches.
+func (hit SearchHit) marked() SearchHit {
+	marks := strings.NewReplacer(snippetStart, "<mark>", snippetEnd, "</mark>")
+	hit.Title = marks.Replace(html.EscapeString(hit.Title))
+	hit.Snippet = marks.Replace(html.EscapeString(hit.Snippet))
+	return hit
+}
@@ -1,9 +1,13 @@
 package articles
 
 import (
-	"github.com/gosimple/slug"
+	"fmt"
+	"realworld-backend/common"
 	"realworld-backend/users"
+	"strings"
+
 	"github.com/gin-gonic/gin"
+	"github.com/pmezard/go-difflib/difflib"
 )
 
 type TagSerializer struct {
@@ -35,10 +39,15 @@ type ArticleUserSerializer struct {
 }
 
 func (s *ArticleUserSerializer) Response() users.ProfileResponse {
-	response := users.ProfileSerializer{s.C, s.ArticleUserModel.UserModel}
+	response := users.ProfileSerializer{C: s.C, UserModel: s.ArticleUserModel.UserModel}
 	return response.Response()
 }
 
+func (s *ArticleUserSerializer) responseFollowing(following map[uint]bool) users.ProfileResponse {
+	response := users.ProfileSerializer{C: s.C, UserModel: s.ArticleUserModel.UserModel}
+	return response.ResponseFollowing(following[s.ArticleUserModel.UserModelID])
+}
+
 type ArticleSerializer struct {
 	C *gin.Context
 	ArticleModel
@@ -56,6 +65,26 @@ type ArticleResponse struct {
 	Tags           []string              `json:"tagList"`
 	Favorite       bool                  `json:"favorited"`
 	FavoritesCount uint                  `json:"favoritesCount"`
+	Reactions      []ReactionResponse    `json:"reactions"`
+	Bookmarked     bool                  `json:"bookmarked"`
+	Status         string                `json:"status"`
+	PublishAt      *string               `json:"publishAt,omitempty"`
+	*RenderedResponse
+}
+
+// Every configured reaction, even those nobody used yet.
+type ReactionResponse struct {
+	Reaction string `json:"reaction"`
+	Emoji    string `json:"emoji"`
+	Count    uint   `json:"count"`
+	Reacted  bool   `json:"reacted"` // by the current user
+}
+
+// Only there with ?format=html, the body stays Markdown either way.
+type RenderedResponse struct {
+	BodyHTML    string     `json:"bodyHtml"`
+	TOC         []TOCEntry `json:"toc"`
+	ReadingTime int        `json:"readingTime"`
 }
 
 type ArticlesSerializer struct {
@@ -63,21 +92,73 @@ type ArticlesSerializer struct {
 	Articles []ArticleModel
 }
 
+// What serializing articles needs besides their preloaded rows, looked up for the whole
+// page at once so a page costs the same few queries whatever its size.
+type articlesContext struct {
+	favoritesCounts map[uint]uint
+	favorited       map[uint]bool
+	following       map[uint]bool // by users.UserModel ID
+	reactionCounts  map[uint]map[string]uint
+	reacted         map[uint]map[string]bool
+	bookmarked      map[uint]bool
+	html            bool
+}
+
+func newArticlesContext(c *gin.Context, articles []ArticleModel) articlesContext {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	var ids, authorIDs []uint
+	for _, article := range articles {
+		ids = append(ids, article.ID)
+		authorIDs = append(authorIDs, article.Author.UserModelID)
+	}
+	return articlesContext{
+		favoritesCounts: favoritesCounts(ids),
+		favorited:       favoritedBy(ids, GetArticleUserModel(myUserModel)),
+		reactionCounts:  reactionCounts(ids),
+		reacted:         reactedBy(ids, GetArticleUserModel(myUserModel)),
+		bookmarked:      bookmarkedBy(ids, myUserModel),
+		following:       myUserModel.FollowingSet(authorIDs),
+		html:            c.Query("format") == "html",
+	}
+}
+
 func (s *ArticleSerializer) Response() ArticleResponse {
-	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
+	return s.response(newArticlesContext(s.C, []ArticleModel{s.ArticleModel}))
+}
+
+func (s *ArticleSerializer) response(ctx articlesContext) ArticleResponse {
 	authorSerializer := ArticleUserSerializer{s.C, s.Author}
 	response := ArticleResponse{
 		ID:          s.ID,
-		Slug:        slug.Make(s.Title),
+		Slug:        s.Slug,
 		Title:       s.Title,
 		Description: s.Description,
 		Body:        s.Body,
 		CreatedAt:   s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
 		//UpdatedAt:      s.UpdatedAt.UTC().Format(time.RFC3339Nano),
 		UpdatedAt:      s.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
-		Author:         authorSerializer.Response(),
-		Favorite:       s.isFavoriteBy(GetArticleUserModel(myUserModel)),
-		FavoritesCount: s.favoritesCount(),
+		Author:         authorSerializer.responseFollowing(ctx.following),
+		Favorite:       ctx.favorited[s.ID],
+		FavoritesCount: ctx.favoritesCounts[s.ID],
+		Bookmarked:     ctx.bookmarked[s.ID],
+		Status:         s.Status,
+	}
+	if s.Status == ArticleScheduled && s.PublishAt != nil {
+		publishAt := s.PublishAt.UTC().Format("2006-01-02T15:04:05.999Z")
+		response.PublishAt = &publishAt
+	}
+	response.Reactions = []ReactionResponse{}
+	for _, reaction := range common.GetConfig().Articles.Reactions {
+		response.Reactions = append(response.Reactions, ReactionResponse{
+			Reaction: reaction.Name,
+			Emoji:    reaction.Emoji,
+			Count:    ctx.reactionCounts[s.ID][reaction.Name],
+			Reacted:  ctx.reacted[s.ID][reaction.Name],
+		})
+	}
+	if ctx.html {
+		rendered := RenderBody(s.Body)
+		response.RenderedResponse = &RenderedResponse{rendered.HTML, rendered.TOC, rendered.ReadingTime}
 	}
 	response.Tags = make([]string, 0)
 	for _, tag := range s.Tags {
@@ -89,13 +170,66 @@ func (s *ArticleSerializer) Response() ArticleResponse {
 
 func (s *ArticlesSerializer) Response() []ArticleResponse {
 	response := []ArticleResponse{}
+	ctx := newArticlesContext(s.C, s.Articles)
 	for _, article := range s.Articles {
 		serializer := ArticleSerializer{s.C, article}
-		response = append(response, serializer.Response())
+		response = append(response, serializer.response(ctx))
 	}
 	return response
 }
 
+// An article in its author's trash.
+type TrashedArticleResponse struct {
+	ArticleResponse
+	DeletedAt string `json:"deletedAt"`
+	PurgeAt   string `json:"purgeAt"`
+}
+
+type TrashSerializer struct {
+	C        *gin.Context
+	Articles []ArticleModel
+}
+
+func (s *TrashSerializer) Response() []TrashedArticleResponse {
+	response := []TrashedArticleResponse{}
+	ctx := newArticlesContext(s.C, s.Articles)
+	for _, article := range s.Articles {
+		serializer := ArticleSerializer{s.C, article}
+		response = append(response, TrashedArticleResponse{
+			ArticleResponse: serializer.response(ctx),
+			DeletedAt:       article.DeletedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+			PurgeAt:         PurgeAt(*article.DeletedAt).UTC().Format("2006-01-02T15:04:05.999Z"),
+		})
+	}
+	return response
+}
+
+// Who reacted with one reaction, as profiles.
+type ReactionsSerializer struct {
+	C         *gin.Context
+	Reactions []ReactionModel
+}
+
+func (s *ReactionsSerializer) Response() []users.ProfileResponse {
+	response := []users.ProfileResponse{}
+	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
+	var userIDs []uint
+	for _, reaction := range s.Reactions {
+		userIDs = append(userIDs, reaction.User.UserModelID)
+	}
+	following := myUserModel.FollowingSet(userIDs)
+	for _, reaction := range s.Reactions {
+		serializer := ArticleUserSerializer{s.C, reaction.User}
+		response = append(response, serializer.responseFollowing(following))
+	}
+	return response
+}
+
+type BookmarkFolderResponse struct {
+	Folder string `json:"folder"`
+	Count  int    `json:"count"`
+}
+
 type CommentSerializer struct {
 	C *gin.Context
 	CommentModel
@@ -107,30 +241,248 @@ type CommentsSerializer struct {
 }
 
 type CommentResponse struct {
-	ID        uint                  `json:"id"`
-	Body      string                `json:"body"`
-	CreatedAt string                `json:"createdAt"`
-	UpdatedAt string                `json:"updatedAt"`
-	Author    users.ProfileResponse `json:"author"`
+	ID        uint                   `json:"id"`
+	Body      string                 `json:"body"`
+	CreatedAt string                 `json:"createdAt"`
+	UpdatedAt string                 `json:"updatedAt"` // when the body last changed
+	Edited    bool                   `json:"edited"`
+	Author    *users.ProfileResponse `json:"author"` // null once deleted
+	ParentID  *uint                  `json:"parentId"`
+	Depth     uint                   `json:"depth"`
+	Replies   []CommentResponse      `json:"replies"`
 }
 
+// What is left of a deleted comment which has replies.
+const deletedCommentBody = "[deleted]"
+
 func (s *CommentSerializer) Response() CommentResponse {
-	authorSerializer := ArticleUserSerializer{s.C, s.Author}
+	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
+	return s.response(myUserModel.FollowingSet([]uint{s.Author.UserModelID}))
+}
+
+func (s *CommentSerializer) response(following map[uint]bool) CommentResponse {
 	response := CommentResponse{
 		ID:        s.ID,
 		Body:      s.Body,
 		CreatedAt: s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
-		UpdatedAt: s.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
-		Author:    authorSerializer.Response(),
+		UpdatedAt: s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+		Edited:    s.EditedAt != nil,
+		ParentID:  s.ParentID,
+		Depth:     s.Depth,
+		Replies:   []CommentResponse{},
+	}
+	if s.EditedAt != nil {
+		response.UpdatedAt = s.EditedAt.UTC().Format("2006-01-02T15:04:05.999Z")
+	}
+	if s.Removed {
+		response.Body = deletedCommentBody
+	} else {
+		authorSerializer := ArticleUserSerializer{s.C, s.Author}
+		author := authorSerializer.responseFollowing(following)
+		response.Author = &author
 	}
 	return response
 }
 
+// The comments as threads: those without a parent among them at the top, each with its
+// replies nested in order.
 func (s *CommentsSerializer) Response() []CommentResponse {
-	response := []CommentResponse{}
+	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
+	var authorIDs []uint
+	ids := map[uint]bool{}
 	for _, comment := range s.Comments {
-		serializer := CommentSerializer{s.C, comment}
-		response = append(response, serializer.Response())
+		authorIDs = append(authorIDs, comment.Author.UserModelID)
+		ids[comment.ID] = true
+	}
+	following := myUserModel.FollowingSet(authorIDs)
+	replies := map[uint][]CommentModel{}
+	var top []CommentModel
+	for _, comment := range s.Comments {
+		if comment.ParentID != nil && ids[*comment.ParentID] {
+			replies[*comment.ParentID] = append(replies[*comment.ParentID], comment)
+		} else {
+			top = append(top, comment)
+		}
+	}
+	var thread func(comments []CommentModel) []CommentResponse
+	thread = func(comments []CommentModel) []CommentResponse {
+		response := []CommentResponse{}
+		for _, comment := range comments {
+			serializer := CommentSerializer{s.C, comment}
+			commentResponse := serializer.response(following)
+			commentResponse.Replies = thread(replies[comment.ID])
+			response = append(response, commentResponse)
+		}
+		return response
+	}
+	return thread(top)
+}
+
+type CommentEditsSerializer struct {
+	C     *gin.Context
+	Edits []CommentEditModel
+}
+
+type CommentEditResponse struct {
+	Body     string                `json:"body"` // what the edit replaced
+	Editor   users.ProfileResponse `json:"editor"`
+	EditedAt string                `json:"editedAt"`
+}
+
+func (s *CommentEditsSerializer) Response() []CommentEditResponse {
+	response := []CommentEditResponse{}
+	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
+	var editorIDs []uint
+	for _, edit := range s.Edits {
+		editorIDs = append(editorIDs, edit.Editor.UserModelID)
+	}
+	following := myUserModel.FollowingSet(editorIDs)
+	for _, edit := range s.Edits {
+		editorSerializer := ArticleUserSerializer{s.C, edit.Editor}
+		response = append(response, CommentEditResponse{
+			Body:     edit.Body,
+			Editor:   editorSerializer.responseFollowing(following),
+			EditedAt: edit.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+		})
+	}
+	return response
+}
+
+// An article found by search, with where the query matched.
+type SearchResultResponse struct {
+	ArticleResponse
+	Search SearchHitResponse `json:"search"`
+}
+
+type SearchHitResponse struct {
+	Title   string `json:"title"`
+	Snippet string `json:"snippet"`
+}
+
+type SearchResultsSerializer struct {
+	C        *gin.Context
+	Articles []ArticleModel
+	Hits     []SearchHit // in the same order as Articles
+}
+
+func (s *SearchResultsSerializer) Response() []SearchResultResponse {
+	response := []SearchResultResponse{}
+	ctx := newArticlesContext(s.C, s.Articles)
+	for i, article := range s.Articles {
+		serializer := ArticleSerializer{s.C, article}
+		response = append(response, SearchResultResponse{
+			ArticleResponse: serializer.response(ctx),
+			Search:          SearchHitResponse{Title: s.Hits[i].Title, Snippet: s.Hits[i].Snippet},
+		})
+	}
+	return response
+}
+
+type RevisionSerializer struct {
+	C *gin.Context
+	ArticleRevisionModel
+}
+
+type RevisionsSerializer struct {
+	C         *gin.Context
+	Revisions []ArticleRevisionModel
+}
+
+type RevisionResponse struct {
+	Number      uint                  `json:"number"`
+	Title       string                `json:"title"`
+	Description string                `json:"description"`
+	Body        string                `json:"body"`
+	Changed     []string              `json:"changed"`
+	Editor      users.ProfileResponse `json:"editor"`
+	CreatedAt   string                `json:"createdAt"`
+}
+
+func (s *RevisionSerializer) Response() RevisionResponse {
+	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
+	return s.response(myUserModel.FollowingSet([]uint{s.Editor.UserModelID}))
+}
+
+func (s *RevisionSerializer) response(following map[uint]bool) RevisionResponse {
+	editorSerializer := ArticleUserSerializer{s.C, s.Editor}
+	response := RevisionResponse{
+		Number:      s.Number,
+		Title:       s.Title,
+		Description: s.Description,
+		Body:        s.Body,
+		Changed:     strings.Split(s.Changed, ","),
+		Editor:      editorSerializer.responseFollowing(following),
+		CreatedAt:   s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+	}
+	if s.Changed == "" {
+		response.Changed = []string{}
+	}
+	return response
+}
+
+func (s *RevisionsSerializer) Response() []RevisionResponse {
+	response := []RevisionResponse{}
+	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
+	var editorIDs []uint
+	for _, revision := range s.Revisions {
+		editorIDs = append(editorIDs, revision.Editor.UserModelID)
+	}
+	following := myUserModel.FollowingSet(editorIDs)
+	for _, revision := range s.Revisions {
+		serializer := RevisionSerializer{s.C, revision}
+		response = append(response, serializer.response(following))
 	}
 	return response
 }
+
+// Two revisions compared, From is 0 for the empty article before the first one.
+type RevisionDiffSerializer struct {
+	C    *gin.Context
+	From ArticleRevisionModel
+	To   ArticleRevisionModel
+}
+
+type RevisionDiffResponse struct {
+	From    uint   `json:"from"`
+	To      uint   `json:"to"`
+	Unified string `json:"unified"`
+}
+
+// A unified diff with a file per field that differs, "a/body" and "b/body", and three
+// lines of context:
+//
+//	--- a/body	revision 2
+//	+++ b/body	revision 3
+//	@@ -1,2 +1,2 @@
+func (s *RevisionDiffSerializer) Response() RevisionDiffResponse {
+	fields := []struct{ name, from, to string }{
+		{"title", s.From.Title, s.To.Title},
+		{"description", s.From.Description, s.To.Description},
+		{"body", s.From.Body, s.To.Body},
+	}
+	var unified strings.Builder
+	for _, field := range fields {
+		if field.from == field.to {
+			continue
+		}
+		diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitDiffLines(field.from),
+			B:        splitDiffLines(field.to),
+			FromFile: "a/" + field.name,
+			FromDate: fmt.Sprintf("revision %v", s.From.Number),
+			ToFile:   "b/" + field.name,
+			ToDate:   fmt.Sprintf("revision %v", s.To.Number),
+			Context:  3,
+		})
+		unified.WriteString(diff)
+	}
+	return RevisionDiffResponse{From: s.From.Number, To: s.To.Number, Unified: unified.String()}
+}
+
+// The lines of a field, an empty one has none rather than a blank line.
+func splitDiffLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return difflib.SplitLines(text)
+}
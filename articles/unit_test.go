@@ -3,12 +3,27 @@ package articles
 import (
     "testing"
 	"time"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"strings"
+	"bufio"
+	"context"
     "github.com/stretchr/testify/assert"
     "github.com/gin-gonic/gin"
     "realworld-backend/users"
 	"github.com/jinzhu/gorm"
+
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"realworld-backend/common"
 )
 
+var test_db *gorm.DB
+
 // Helper to create TagModels from strings
 func makeTags(tags []string) []TagModel {
     var tagModels []TagModel
@@ -220,4 +235,2458 @@ func TestArticleModelValidatorWhitespaceTitle(t *testing.T) {
     validator.Article.Description = "Desc"
     ok := isArticleValid(validator)
     assert.False(t, ok)
-}
\ No newline at end of file
+}
+
+// --- Router Tests ---
+
+func userModelMocker(n int) []users.UserModel {
+	var offset int
+	test_db.Model(&users.UserModel{}).Count(&offset)
+	var ret []users.UserModel
+	for i := offset + 1; i <= offset+n; i++ {
+		userModel := users.UserModel{
+			Username:     fmt.Sprintf("user%v", i),
+			Email:        fmt.Sprintf("user%v@linkedin.com", i),
+			Bio:          fmt.Sprintf("bio%v", i),
+			PasswordHash: "hashed",
+		}
+		test_db.Create(&userModel)
+		ret = append(ret, userModel)
+	}
+	return ret
+}
+
+// user1 writes "hello-world", which gets comment 1 from user2 and comment 2 from user1.
+func articleModelMocker(author, commenter users.UserModel) ArticleModel {
+	articleModel := ArticleModel{
+		Slug:        "hello-world",
+		Title:       "Hello World",
+		Description: "desc",
+		Body:        "body",
+		Author:      GetArticleUserModel(author),
+	}
+	test_db.Create(&articleModel)
+	test_db.Create(&CommentModel{ArticleID: articleModel.ID, Author: GetArticleUserModel(commenter), Body: "from user2"})
+	test_db.Create(&CommentModel{ArticleID: articleModel.ID, Author: GetArticleUserModel(author), Body: "from user1"})
+	return articleModel
+}
+
+//Reset test DB and create new one with mock data
+func resetDBWithMock() {
+	common.TestDBFree(test_db)
+	test_db = common.TestDBInit()
+	users.AutoMigrate()
+	AutoMigrate()
+	userModels := userModelMocker(2)
+	articleModelMocker(userModels[0], userModels[1])
+}
+
+func HeaderTokenMock(req *http.Request, u uint) {
+	req.Header.Set("Authorization", fmt.Sprintf("Token %v", common.GenToken(u)))
+}
+
+var ownershipRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	bodyData       string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	//---------------------   Testing for article update   ---------------------
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+		},
+		"/articles/hello-world",
+		"PUT",
+		`{"article":{"body":"hacked"}}`,
+		http.StatusUnauthorized,
+		``,
+		"anonymous user should not update an article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world",
+		"PUT",
+		`{"article":{"body":"hacked"}}`,
+		http.StatusForbidden,
+		`{"errors":{"article":"You are not the author"}}`,
+		"non-owner should not update an article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world",
+		"PUT",
+		`{"article":{"body":"edited"}}`,
+		http.StatusOK,
+		`"body":"edited"`,
+		"owner should update an article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/not-exist",
+		"PUT",
+		`{"article":{"body":"edited"}}`,
+		http.StatusNotFound,
+		`{"errors":{"articles":"Invalid slug"}}`,
+		"update a missing article should return 404",
+	},
+
+	//---------------------   Testing for comment delete   ---------------------
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world/comments/2",
+		"DELETE",
+		``,
+		http.StatusUnauthorized,
+		``,
+		"anonymous user should not delete a comment",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/comments/2",
+		"DELETE",
+		``,
+		http.StatusForbidden,
+		`{"errors":{"comment":"You are not the author"}}`,
+		"non-owner should not delete a comment",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments/2",
+		"DELETE",
+		``,
+		http.StatusOK,
+		`{"comment":"Delete success"}`,
+		"owner should delete a comment",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments/2",
+		"DELETE",
+		``,
+		http.StatusNotFound,
+		`{"errors":{"comment":"Invalid id"}}`,
+		"deleted comment should not be deleted again",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments/99",
+		"DELETE",
+		``,
+		http.StatusNotFound,
+		`{"errors":{"comment":"Invalid id"}}`,
+		"missing comment should return 404",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/no-such-article/comments/1",
+		"DELETE",
+		``,
+		http.StatusNotFound,
+		`{"errors":{"comment":"Invalid slug"}}`,
+		"comment of a missing article should return 404",
+	},
+	{
+		func(req *http.Request) {
+			var userModels []users.UserModel
+			test_db.Order("id").Find(&userModels)
+			other := ArticleModel{Slug: "other-article", Title: "Other Article", Author: GetArticleUserModel(userModels[1])}
+			test_db.Create(&other)
+			test_db.Create(&CommentModel{ArticleID: other.ID, Author: GetArticleUserModel(userModels[0]), Body: "on the other article"})
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments/3",
+		"DELETE",
+		``,
+		http.StatusNotFound,
+		`{"errors":{"comment":"Invalid id"}}`,
+		"comment should not be deleted through another article's slug",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments/3",
+		"PUT",
+		`{"comment":{"body":"moved"}}`,
+		http.StatusNotFound,
+		`{"errors":{"comment":"Invalid id"}}`,
+		"comment should not be edited through another article's slug",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/other-article/comments",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"comments":\[{"id":3,"body":"on the other article"`,
+		"comment should survive attempts through another slug",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/other-article/comments/3",
+		"DELETE",
+		``,
+		http.StatusOK,
+		`{"comment":"Delete success"}`,
+		"comment should be deleted through its own article's slug",
+	},
+
+	//---------------------   Testing for article delete   ---------------------
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world",
+		"DELETE",
+		``,
+		http.StatusUnauthorized,
+		``,
+		"anonymous user should not delete an article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world",
+		"DELETE",
+		``,
+		http.StatusForbidden,
+		`{"errors":{"article":"You are not the author"}}`,
+		"non-owner should not delete an article",
+	},
+	{
+		func(req *http.Request) {
+			userModelMocker(1)
+			HeaderTokenMock(req, 3)
+		},
+		"/articles/hello-world",
+		"PUT",
+		`{"article":{"body":"hacked"}}`,
+		http.StatusForbidden,
+		`{"errors":{"article":"You are not the author"}}`,
+		"user who never wrote anything should not update an article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world",
+		"DELETE",
+		``,
+		http.StatusOK,
+		`{"article":"Delete success"}`,
+		"owner should delete an article",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world",
+		"GET",
+		``,
+		http.StatusNotFound,
+		`{"errors":{"articles":"Invalid slug"}}`,
+		"deleted article should be gone",
+	},
+}
+
+func TestOwnership(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	for _, testData := range ownershipRequestTests {
+		bodyData := testData.bodyData
+		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+	var authors int
+	test_db.Model(&ArticleUserModel{}).Where("user_model_id = ?", 3).Count(&authors)
+	asserts.Equal(0, authors, "checking the owner should not make an author")
+}
+
+var slugRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	bodyData       string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/",
+		"POST",
+		`{"article":{"title":"Hello World","description":"desc","body":"body"}}`,
+		http.StatusCreated,
+		`"slug":"hello-world-2"`,
+		"same title should get a suffixed slug",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/",
+		"POST",
+		`{"article":{"title":"Hello, World!","description":"desc","body":"body"}}`,
+		http.StatusCreated,
+		`"slug":"hello-world-3"`,
+		"title with the same slug should get the next suffix",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world",
+		"PUT",
+		`{"article":{"body":"edited"}}`,
+		http.StatusOK,
+		`"slug":"hello-world"`,
+		"slug should be kept when the title doesn't change",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world",
+		"PUT",
+		`{"article":{"title":"Goodbye World"}}`,
+		http.StatusOK,
+		`"slug":"goodbye-world","description":"desc","body":"edited"`,
+		"new title should move the article to a new slug",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world",
+		"GET",
+		``,
+		http.StatusMovedPermanently,
+		`{"article":{"title":"Goodbye World","slug":"goodbye-world"`,
+		"old slug should redirect to the current article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/",
+		"POST",
+		`{"article":{"title":"Hello World","description":"desc","body":"body"}}`,
+		http.StatusCreated,
+		`"slug":"hello-world-4"`,
+		"old slug of another article should not be reused",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/goodbye-world",
+		"PUT",
+		`{"article":{"title":"Hello World"}}`,
+		http.StatusOK,
+		`"slug":"hello-world"`,
+		"article should get its own old slug back",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"article":{"title":"Hello World","slug":"hello-world"`,
+		"slug given back should not redirect",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/goodbye-world",
+		"GET",
+		``,
+		http.StatusMovedPermanently,
+		`"slug":"hello-world"`,
+		"slug left behind should redirect",
+	},
+}
+
+func TestSlugs(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	for _, testData := range slugRequestTests {
+		bodyData := testData.bodyData
+		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+		if w.Code == http.StatusMovedPermanently {
+			asserts.Regexp(`^/articles/[a-z-]+$`, w.Header().Get("Location"), "Location - "+testData.msg)
+		}
+	}
+}
+
+var trashRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world", "DELETE", http.StatusOK, `"article":"Delete success"`,
+		"author should delete the article",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world", "GET", http.StatusNotFound, `Invalid slug`,
+		"deleted article should not be found",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/trash", "GET", http.StatusOK, `{"articles":\[{"title":"Hello World","slug":"hello-world",.*"deletedAt":"[^"]+","purgeAt":"[^"]+"}\],"articlesCount":1,`,
+		"deleted article should be in the author's trash",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/trash", "GET", http.StatusOK, `{"articles":\[\],"articlesCount":0,`,
+		"trash should only hold the user's articles",
+	},
+	{
+		func(req *http.Request) {},
+		"/user/trash", "GET", http.StatusUnauthorized, ``,
+		"trash should require auth",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/restore", "POST", http.StatusForbidden, `You are not the author`,
+		"only the author should restore",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/restore", "POST", http.StatusOK, `{"article":{"title":"Hello World","slug":"hello-world"`,
+		"author should restore the article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/restore", "POST", http.StatusNotFound, `Invalid slug`,
+		"article which is not in the trash should not be restored",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world/comments", "GET", http.StatusOK, `{"comments":\[{"id":1,.*{"id":2,`,
+		"restored article should keep its comments",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/trash", "GET", http.StatusOK, `{"articles":\[\],"articlesCount":0,`,
+		"restored article should leave the trash",
+	},
+}
+
+func TestTrash(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	UserArticlesRegister(r.Group("/user"))
+	for _, testData := range trashRequestTests {
+		req, err := http.NewRequest(testData.method, testData.url, nil)
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+func TestTrashOrder(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	var userModel users.UserModel
+	test_db.Order("id").First(&userModel)
+	author := GetArticleUserModel(userModel)
+
+	// Deleted out of creation order: the newer article first, hello-world last.
+	newer := ArticleModel{Title: "Newer", Author: author}
+	CreateArticle(&newer)
+	now := time.Now()
+	for slug, deletedAt := range map[string]time.Time{"newer": now.Add(-time.Hour), "hello-world": now} {
+		asserts.NoError(DeleteArticleModel(&ArticleModel{Slug: slug}))
+		test_db.Unscoped().Model(&ArticleModel{}).Where("slug = ?", slug).UpdateColumn("deleted_at", deletedAt)
+	}
+
+	models, count, cursors, err := author.GetTrash(common.Page{Limit: 1})
+	asserts.NoError(err)
+	asserts.Equal(2, count)
+	if asserts.Len(models, 1) {
+		asserts.Equal("hello-world", models[0].Slug, "the last deleted should come first")
+	}
+	asserts.NotNil(cursors.Next)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/?limit=1&after="+*cursors.Next, nil)
+	after, _, err := common.ParsePage(c)
+	asserts.NoError(err)
+	models, _, cursors, err = author.GetTrash(after)
+	asserts.NoError(err)
+	if asserts.Len(models, 1) {
+		asserts.Equal("newer", models[0].Slug, "the first deleted should come last")
+	}
+	asserts.Nil(cursors.Next)
+}
+
+func TestPurgeTrash(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	var userModel users.UserModel
+	test_db.Order("id").First(&userModel)
+	author := GetArticleUserModel(userModel)
+
+	// hello-world has comments, a favorite, a reaction, a bookmark, a tag, a notification and an old slug, and was deleted long ago.
+	articleModel, _ := FindOneArticle(&ArticleModel{Slug: "hello-world"})
+	articleModel.favoriteBy(author)
+	articleModel.reactBy(author, "like")
+	articleModel.bookmarkBy(userModel, "")
+	articleModel.setTags([]string{"go"})
+	SaveOne(&articleModel)
+	var reader users.UserModel
+	test_db.Order("id").Offset(1).First(&reader)
+	users.Notify(users.NotificationModel{UserID: userModel.ID, Kind: users.NotifyFavorite, ArticleID: articleModel.ID, ArticleSlug: articleModel.Slug}, reader)
+	articleModel.Update(ArticleModel{Title: "Hello Again"})
+	asserts.NoError(DeleteArticleModel(&ArticleModel{Slug: "hello-again"}))
+	test_db.Unscoped().Model(&articleModel).UpdateColumn("deleted_at", time.Now().Add(-31*24*time.Hour))
+
+	recent := ArticleModel{Title: "Recently deleted", Author: author}
+	CreateArticle(&recent)
+	DeleteArticleModel(&ArticleModel{Slug: recent.Slug})
+
+	asserts.NoError(PurgeTrash())
+
+	count := func(model interface{}, query string, args ...interface{}) int {
+		var n int
+		test_db.Unscoped().Model(model).Where(query, args...).Count(&n)
+		return n
+	}
+	asserts.Equal(0, count(&ArticleModel{}, "id = ?", articleModel.ID), "old article should be purged")
+	asserts.Equal(0, count(&CommentModel{}, "article_id = ?", articleModel.ID), "comments should be purged")
+	asserts.Equal(0, count(&FavoriteModel{}, "favorite_id = ?", articleModel.ID), "favorites should be purged")
+	asserts.Equal(0, count(&ArticleSlugModel{}, "article_id = ?", articleModel.ID), "old slugs should be purged")
+	asserts.Equal(0, count(&ArticleRevisionModel{}, "article_id = ?", articleModel.ID), "revisions should be purged")
+	asserts.Equal(0, count(&ReactionModel{}, "article_id = ?", articleModel.ID), "reactions should be purged")
+	asserts.Equal(0, count(&BookmarkModel{}, "article_id = ?", articleModel.ID), "bookmarks should be purged")
+	asserts.Equal(0, count(&users.NotificationModel{}, "article_id = ?", articleModel.ID), "notifications should be purged")
+	asserts.Equal(0, count(&users.NotificationActorModel{}, "1 = 1"), "notification actors should be purged")
+	var tags int
+	test_db.Table("article_tags").Where("article_model_id = ?", articleModel.ID).Count(&tags)
+	asserts.Equal(0, tags, "tags should be purged")
+	asserts.Equal(1, count(&ArticleModel{}, "id = ?", recent.ID), "recently deleted article should stay in the trash")
+
+	again := ArticleModel{Title: "Hello Again", Author: author}
+	CreateArticle(&again)
+	asserts.Equal("hello-again", again.Slug, "purged slug should be free again")
+	old := ArticleModel{Title: "Hello World", Author: author}
+	CreateArticle(&old)
+	asserts.Equal("hello-world", old.Slug, "purged old slug should be free again")
+}
+
+var statusRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	bodyData       string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/", "POST", `{"article":{"title":"My Draft","body":"wip","status":"draft"}}`,
+		http.StatusCreated, `"slug":"my-draft",.*"status":"draft"}}`,
+		"author should save a draft",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/", "POST", `{"article":{"title":"Later","status":"scheduled"}}`,
+		http.StatusUnprocessableEntity, `{"errors":{"PublishAt":"{required_if: Status scheduled}"}}`,
+		"scheduled article should need a publishAt",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/", "POST", `{"article":{"title":"Later","status":"soon"}}`,
+		http.StatusUnprocessableEntity, `{"errors":{"Status":"{oneof: draft scheduled published}"}}`,
+		"unknown status should be rejected",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/", "POST", `{"article":{"title":"Later","status":"scheduled","publishAt":"2099-01-01T00:00:00Z"}}`,
+		http.StatusCreated, `"slug":"later",.*"status":"scheduled","publishAt":"2099-01-01T00:00:00Z"}}`,
+		"author should schedule an article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/", "POST", `{"article":{"title":"Right Away"}}`,
+		http.StatusCreated, `"slug":"right-away",.*"status":"published"}}`,
+		"article should be published by default",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/my-draft", "GET", ``,
+		http.StatusNotFound, `Invalid slug`,
+		"draft should be hidden from anonymous users",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/later", "GET", ``,
+		http.StatusNotFound, `Invalid slug`,
+		"scheduled article should be hidden from other users",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/my-draft/favorite", "POST", ``,
+		http.StatusNotFound, `Invalid slug`,
+		"draft should not be favorited by other users",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/my-draft/comments", "GET", ``,
+		http.StatusNotFound, `Invalid slug`,
+		"comments of a draft should be hidden from other users",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/my-draft", "GET", ``,
+		http.StatusOK, `"slug":"my-draft"`,
+		"author should see the draft",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/", "GET", ``,
+		http.StatusOK, `{"articles":\[{"title":"Right Away".*{"title":"Hello World".*\],"articlesCount":2,`,
+		"list should only have published articles, even for their author",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/drafts", "GET", ``,
+		http.StatusOK, `{"articles":\[{"title":"Later".*{"title":"My Draft".*\],"articlesCount":2,`,
+		"author should list drafts and scheduled articles",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/drafts", "GET", ``,
+		http.StatusOK, `{"articles":\[\],"articlesCount":0,`,
+		"drafts should only be listed to their author",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/later", "PUT", `{"article":{"status":"draft"}}`,
+		http.StatusOK, `"status":"draft"}}`,
+		"unscheduling should drop the publishAt",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/my-draft", "PUT", `{"article":{"status":"published"}}`,
+		http.StatusOK, `"slug":"my-draft",.*"status":"published"}}`,
+		"author should publish the draft",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/my-draft", "GET", ``,
+		http.StatusOK, `"slug":"my-draft"`,
+		"published draft should be public",
+	},
+}
+
+func TestArticleStatus(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	UserArticlesRegister(r.Group("/user"))
+	for _, testData := range statusRequestTests {
+		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(testData.bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+func TestPublishScheduled(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	var userModel users.UserModel
+	test_db.Order("id").First(&userModel)
+	author := GetArticleUserModel(userModel)
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	due := ArticleModel{Title: "Due", Body: "due", Author: author, Status: ArticleScheduled, PublishAt: &past}
+	CreateArticle(&due)
+	later := ArticleModel{Title: "Not yet", Body: "due", Author: author, Status: ArticleScheduled, PublishAt: &future}
+	CreateArticle(&later)
+
+	asserts.True(due.IsPublished(time.Now()), "article should be public from its publishAt on")
+	asserts.False(later.IsPublished(time.Now()), "article should be hidden before its publishAt")
+	_, count, _, _ := FindManyArticle(ArticleFilter{}, common.Page{Limit: 10})
+	asserts.Equal(2, count, "due article should be listed before the publisher ran")
+	_, hits, _, _ := SearchArticles([]string{"due"}, users.UserModel{}, common.Page{Limit: 10})
+	asserts.Len(hits, 1, "only the due article should be found")
+
+	published, err := PublishScheduled()
+	asserts.NoError(err)
+	asserts.Equal(int64(1), published, "only the due article should be published")
+	due, _ = FindOneArticle(&ArticleModel{Slug: "due"})
+	asserts.Equal(ArticlePublished, due.Status)
+	asserts.Nil(due.PublishAt, "published article should drop its publishAt")
+	later, _ = FindOneArticle(&ArticleModel{Slug: "not-yet"})
+	asserts.Equal(ArticleScheduled, later.Status, "future article should stay scheduled")
+}
+
+var revisionRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	bodyData       string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/", "POST", `{"article":{"title":"First Title","body":"line1\nline2\nline3"}}`,
+		http.StatusCreated, `"slug":"first-title"`,
+		"creating should record revision 1",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/first-title", "PUT", `{"article":{"description":"added","body":"line1\nline two\nline3"}}`,
+		http.StatusOK, `"description":"added","body":"line1\\nline two\\nline3"`,
+		"editing the body should record revision 2",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/first-title", "PUT", `{"article":{"title":"Second Title"}}`,
+		http.StatusOK, `"slug":"second-title"`,
+		"editing the title should record revision 3",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/second-title", "PUT", `{"article":{"status":"published"}}`,
+		http.StatusOK, `"slug":"second-title"`,
+		"saving without changing the text should not record a revision",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/second-title/revisions", "GET", ``,
+		http.StatusOK, `"revisions":\[{"number":3,"title":"Second Title",.*"changed":\["title"\],"editor":{"username":"user1".*{"number":2,.*"changed":\["description","body"\].*{"number":1,.*"changed":\["title","description","body"\].*\],"revisionsCount":3}`,
+		"revisions should be listed newest first",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/second-title/revisions/2", "GET", ``,
+		http.StatusOK, `{"revision":{"number":2,"title":"First Title","description":"added","body":"line1\\nline two\\nline3"`,
+		"revision should have the text after that save",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/second-title/revisions/9", "GET", ``,
+		http.StatusNotFound, `Invalid number`,
+		"unknown revision should be not found",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/second-title/revisions/two", "GET", ``,
+		http.StatusNotFound, `Invalid number`,
+		"revision should be a number",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/second-title/revisions/2/diff", "GET", ``,
+		http.StatusOK, `{"diff":{"from":1,"to":2,"unified":"--- a/description\\trevision 1\\n\+\+\+ b/description\\trevision 2\\n@@ -0,0 \+1 @@\\n\+added\\n--- a/body\\trevision 1\\n\+\+\+ b/body\\trevision 2\\n@@ -1,3 \+1,3 @@\\n line1\\n-line2\\n\+line two\\n line3\\n"}}`,
+		"diff should compare with the revision before",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/second-title/revisions/3/diff?from=1", "GET", ``,
+		http.StatusOK, `{"diff":{"from":1,"to":3,"unified":"--- a/title.*-First Title\\n\+Second Title\\n--- a/description.*--- a/body`,
+		"diff should compare with any revision",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/second-title/revisions/3/diff?from=last", "GET", ``,
+		http.StatusUnprocessableEntity, `should be a revision number`,
+		"bad from should be rejected",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/second-title/revert/1", "POST", ``,
+		http.StatusForbidden, `You are not the author`,
+		"only the author should revert",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/second-title/revert/1", "POST", ``,
+		http.StatusOK, `"slug":"first-title","description":"","body":"line1\\nline2\\nline3"`,
+		"revert should restore the text, even a blank description",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/first-title/revisions?limit=1", "GET", ``,
+		http.StatusOK, `"revisions":\[{"number":4,"title":"First Title","description":"","body":"line1\\nline2\\nline3","changed":\["title","description","body"\].*\],"revisionsCount":4}`,
+		"revert should be recorded as a new revision",
+	},
+}
+
+func TestRevisions(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	for _, testData := range revisionRequestTests {
+		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(testData.bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+var commentThreadTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	bodyData       string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments", "POST", `{"comment":{"body":"reply","parentId":1}}`,
+		http.StatusCreated, `{"comment":{"id":3,"body":"reply",.*"parentId":1,"depth":1,"replies":\[\]}}`,
+		"reply should be under its parent",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/comments", "POST", `{"comment":{"body":"reply to reply","parentId":3}}`,
+		http.StatusCreated, `"id":4,.*"parentId":3,"depth":2`,
+		"replies should nest",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments", "POST", `{"comment":{"body":"reply","parentId":99}}`,
+		http.StatusUnprocessableEntity, `{"errors":{"parentId":"is not a comment of this article"}}`,
+		"parent should exist",
+	},
+	{
+		func(req *http.Request) {
+			var userModel users.UserModel
+			test_db.First(&userModel, 1)
+			other := ArticleModel{Slug: "other", Title: "Other", Author: GetArticleUserModel(userModel)}
+			test_db.Create(&other)
+			test_db.Create(&CommentModel{ArticleID: other.ID, AuthorID: other.AuthorID, Body: "elsewhere"})
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments", "POST", `{"comment":{"body":"reply","parentId":5}}`,
+		http.StatusUnprocessableEntity, `{"errors":{"parentId":"is not a comment of this article"}}`,
+		"parent should be on the same article",
+	},
+	{
+		func(req *http.Request) {
+			parentID := uint(2)
+			test_db.Create(&CommentModel{ArticleID: 1, AuthorID: 1, Body: "deep", ParentID: &parentID, Depth: maxCommentDepth - 1})
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments", "POST", `{"comment":{"body":"deeper","parentId":6}}`,
+		http.StatusUnprocessableEntity, `{"errors":{"parentId":"replies can't nest more than 5 deep"}}`,
+		"replies should not nest too deep",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world/comments", "GET", ``,
+		http.StatusOK, `{"comments":\[{"id":1,"body":"from user2",.*"parentId":null,"depth":0,"replies":\[{"id":3,.*"parentId":1,"depth":1,"replies":\[{"id":4,.*"depth":2,"replies":\[\]}\]}\]},{"id":2,.*"replies":\[{"id":6,.*"depth":4,"replies":\[\]}\]}\]`,
+		"comments should be listed as threads",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/comments/1", "DELETE", ``,
+		http.StatusOK, `Delete success`,
+		"deleting a comment with replies should succeed",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world/comments", "GET", ``,
+		http.StatusOK, `{"comments":\[{"id":1,"body":"\[deleted\]",[^{]*"author":null,"parentId":null,"depth":0,"replies":\[{"id":3,"body":"reply"`,
+		"deleted comment should stay as a placeholder for its replies",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments", "POST", `{"comment":{"body":"reply","parentId":1}}`,
+		http.StatusUnprocessableEntity, `is not a comment of this article`,
+		"deleted comment should not get new replies",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments/3", "DELETE", ``,
+		http.StatusOK, `Delete success`,
+		"deleting a reply with replies should leave a placeholder too",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/comments/4", "DELETE", ``,
+		http.StatusOK, `Delete success`,
+		"deleting the last reply should succeed",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world/comments", "GET", ``,
+		http.StatusOK, `^{"comments":\[{"id":2,.*"replies":\[{"id":6,.*"replies":\[\]}\]}\],"nextCursor"`,
+		"placeholders should go with their last reply",
+	},
+}
+
+func TestCommentThreads(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	for _, testData := range commentThreadTests {
+		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(testData.bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+	var left int
+	test_db.Model(&CommentModel{}).Where("id IN (?)", []uint{1, 3, 4}).Count(&left)
+	asserts.Equal(0, left, "the thread should be gone")
+}
+
+var commentEditTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	bodyData       string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			userModelMocker(1)
+		},
+		"/articles/hello-world/comments/2", "PUT", `{"comment":{"body":"hacked"}}`,
+		http.StatusUnauthorized, ``,
+		"anonymous user should not edit a comment",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/comments/2", "PUT", `{"comment":{"body":"hacked"}}`,
+		http.StatusForbidden, `You are not the author`,
+		"only the author should edit a comment",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments/9", "PUT", `{"comment":{"body":"edited"}}`,
+		http.StatusNotFound, `Invalid id`,
+		"unknown comment should be not found",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments/2", "PUT", `{"comment":{"body":"from user1"}}`,
+		http.StatusOK, `{"comment":{"id":2,"body":"from user1","createdAt":"([^"]+)","updatedAt":"([^"]+)","edited":false,"author":{"username":"user1"`,
+		"the same body should not be an edit",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments/2", "PUT", `{"comment":{"body":"edited once"}}`,
+		http.StatusOK, `{"comment":{"id":2,"body":"edited once",.*"edited":true,"author":{"username":"user1"`,
+		"author should edit their comment",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments/2", "PUT", `{"comment":{"body":"edited twice"}}`,
+		http.StatusOK, `"body":"edited twice"`,
+		"comments should be edited again",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world/comments", "GET", ``,
+		http.StatusOK, `{"comments":\[{"id":1,"body":"from user2",.*"edited":false,.*{"id":2,"body":"edited twice",.*"edited":true`,
+		"list should tell edited comments",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world/comments/2/edits", "GET", ``,
+		http.StatusUnauthorized, ``,
+		"anonymous user should not see the edit history",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/comments/2/edits", "GET", ``,
+		http.StatusForbidden, `You are not the author`,
+		"other users should not see the edit history",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments/2/edits", "GET", ``,
+		http.StatusOK, `{"edits":\[{"body":"edited once","editor":{"username":"user1".*},"editedAt":"[^"]+"},{"body":"from user1",.*\],"editsCount":2,`,
+		"author should see the edit history, newest first",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/articles/hello-world/comments/2/edits?limit=1", "GET", ``,
+		http.StatusOK, `{"edits":\[{"body":"edited once",[^\]]*\],"editsCount":2,"nextCursor":"`,
+		"moderators should see the edit history",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments/2", "DELETE", ``,
+		http.StatusOK, `{"comment":"Delete success"}`,
+		"author should delete their edited comment",
+	},
+}
+
+func TestCommentEdits(t *testing.T) {
+	asserts := assert.New(t)
+	t.Setenv("ARTICLES_MODERATORS", "User3") // matched whatever the case
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	for _, testData := range commentEditTests {
+		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(testData.bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+	var edits int
+	test_db.Model(&CommentEditModel{}).Where("comment_id = ?", 2).Count(&edits)
+	asserts.Equal(0, edits, "edit history should go with the comment")
+}
+
+var reactionRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	bodyData       string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/reactions/like", "POST", ``,
+		http.StatusOK, `"favorited":false,"favoritesCount":0,"reactions":\[{"reaction":"like","emoji":"👍","count":1,"reacted":true},{"reaction":"insightful","emoji":"💡","count":0,"reacted":false},`,
+		"user should react to an article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/reactions/like", "POST", ``,
+		http.StatusOK, `{"reaction":"like","emoji":"👍","count":1,"reacted":true}`,
+		"reacting twice the same way should count once",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/reactions/funny", "POST", ``,
+		http.StatusOK, `{"reaction":"like","emoji":"👍","count":1,"reacted":true},.*{"reaction":"funny","emoji":"😂","count":1,"reacted":true}`,
+		"user should react in several ways",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/reactions/like", "POST", ``,
+		http.StatusOK, `{"reaction":"like","emoji":"👍","count":2,"reacted":true},.*{"reaction":"funny","emoji":"😂","count":1,"reacted":false}`,
+		"reactions should be counted per user",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/reactions/angry", "POST", ``,
+		http.StatusNotFound, `{"errors":{"reaction":"Invalid reaction"}}`,
+		"only configured reactions should be used",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world/reactions/like", "POST", ``,
+		http.StatusUnauthorized, ``,
+		"anonymous user should not react",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world", "GET", ``,
+		http.StatusOK, `{"reaction":"like","emoji":"👍","count":2,"reacted":false}`,
+		"anonymous user should see the counts",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world/reactions/like", "GET", ``,
+		http.StatusOK, `{"nextCursor":null,"prevCursor":null,"profiles":\[{"username":"user1",.*},{"username":"user2",.*}\],"profilesCount":2}`,
+		"who reacted should be listed, latest first",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/hello-world/reactions/angry", "GET", ``,
+		http.StatusNotFound, `Invalid reaction`,
+		"unknown reaction should not be listed",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/reactions/like", "DELETE", ``,
+		http.StatusOK, `{"reaction":"like","emoji":"👍","count":1,"reacted":false},.*{"reaction":"funny","emoji":"😂","count":1,"reacted":true}`,
+		"user should take back one reaction",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/favorite", "POST", ``,
+		http.StatusOK, `"favorited":true,"favoritesCount":1,"reactions":\[{"reaction":"like","emoji":"👍","count":1,"reacted":false}`,
+		"favorites should stay apart from reactions",
+	},
+}
+
+func TestReactions(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	for _, testData := range reactionRequestTests {
+		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(testData.bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+var bookmarkRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	bodyData       string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/bookmark", "POST", ``,
+		http.StatusOK, `"slug":"hello-world",.*"bookmarked":true`,
+		"user should bookmark an article without folder",
+	},
+	{
+		func(req *http.Request) {
+			var userModel users.UserModel
+			test_db.First(&userModel, 1)
+			CreateArticle(&ArticleModel{Title: "Second", Author: GetArticleUserModel(userModel)})
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/second/bookmark", "POST", `{"bookmark":{"folder":" later "}}`,
+		http.StatusOK, `"slug":"second",.*"bookmarked":true`,
+		"user should bookmark an article in a folder",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/second/bookmark", "POST", `{"bookmark":{"folder":"` + strings.Repeat("x", 65) + `"}}`,
+		http.StatusUnprocessableEntity, `{"errors":{"Folder":"{max: 64}"}}`,
+		"folder names should not be too long",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world", "GET", ``,
+		http.StatusOK, `"bookmarked":false`,
+		"bookmarks should be per user",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/bookmarks", "GET", ``,
+		http.StatusOK, `{"articles":\[{"title":"Second",.*"bookmarked":true.*},{"title":"Hello World",.*"bookmarked":true.*}\],"articlesCount":2,`,
+		"user should list their bookmarks",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/bookmarks?folder=later", "GET", ``,
+		http.StatusOK, `{"articles":\[{"title":"Second",.*"bookmarked":true,"status":"published"}\],"articlesCount":1,`,
+		"user should list one folder",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/bookmarks?folder=", "GET", ``,
+		http.StatusOK, `{"articles":\[{"title":"Hello World",.*"bookmarked":true,"status":"published"}\],"articlesCount":1,`,
+		"empty folder should list the bookmarks without folder",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/bookmarks/folders", "GET", ``,
+		http.StatusOK, `{"folders":\[{"folder":"","count":1},{"folder":"later","count":1}\]}`,
+		"user should list their folders",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/bookmark", "POST", `{"bookmark":{"folder":"later"}}`,
+		http.StatusOK, `"bookmarked":true`,
+		"bookmarking again should move the bookmark",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/bookmarks/folders", "GET", ``,
+		http.StatusOK, `{"folders":\[{"folder":"later","count":2}\]}`,
+		"moved bookmark should be in its new folder",
+	},
+	{
+		func(req *http.Request) {
+			// hello-world is the older article but the latest bookmarked
+			test_db.Model(&BookmarkModel{}).Where("article_id = ?", 1).UpdateColumn("created_at", time.Now().Add(time.Hour))
+			HeaderTokenMock(req, 2)
+		},
+		"/user/bookmarks", "GET", ``,
+		http.StatusOK, `{"articles":\[{"title":"Hello World",.*},{"title":"Second",.*}\],"articlesCount":2,`,
+		"bookmarks should be listed the latest bookmarked first",
+	},
+	{
+		func(req *http.Request) {
+			test_db.Model(&ArticleModel{}).Where("slug = ?", "second").UpdateColumn("status", ArticleDraft)
+			HeaderTokenMock(req, 2)
+		},
+		"/user/bookmarks", "GET", ``,
+		http.StatusOK, `{"articles":\[{"title":"Hello World",.*"bookmarked":true,"status":"published"}\],"articlesCount":1,`,
+		"articles which are not visible any more should be left out",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/bookmarks/folders", "GET", ``,
+		http.StatusOK, `{"folders":\[{"folder":"later","count":1}\]}`,
+		"folders should not count the bookmarks left out",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/bookmark", "DELETE", ``,
+		http.StatusOK, `"bookmarked":false`,
+		"user should remove a bookmark",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/bookmarks", "GET", ``,
+		http.StatusOK, `{"articles":\[\],"articlesCount":0,`,
+		"removed bookmark should not be listed",
+	},
+	{
+		func(req *http.Request) {},
+		"/user/bookmarks", "GET", ``,
+		http.StatusUnauthorized, ``,
+		"anonymous user should have no bookmarks",
+	},
+}
+
+func TestBookmarks(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	UserArticlesRegister(r.Group("/user"))
+	for _, testData := range bookmarkRequestTests {
+		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(testData.bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+var blockRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	bodyData       string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			var userModel users.UserModel
+			test_db.First(&userModel, 2)
+			CreateArticle(&ArticleModel{Title: "By User2", Author: GetArticleUserModel(userModel)})
+			test_db.Create(&users.BlockModel{BlockerID: 1, BlockedID: 2})
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/favorite", "POST", ``,
+		http.StatusForbidden, `{"errors":{"articles":"You are blocked by the author"}}`,
+		"blocked user should not favorite the blocker's article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/comments", "POST", `{"comment":{"body":"still here"}}`,
+		http.StatusForbidden, `{"errors":{"comment":"You are blocked by the author"}}`,
+		"blocked user should not comment on the blocker's article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/reactions/like", "POST", ``,
+		http.StatusForbidden, `{"errors":{"reaction":"You are blocked by the author"}}`,
+		"blocked user should not react to the blocker's article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/by-user2/favorite", "POST", ``,
+		http.StatusOK, `"slug":"by-user2",.*"favorited":true`,
+		"block should only work one way",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/", "GET", ``,
+		http.StatusOK, `^{"articles":\[{"title":"Hello World",.*\],"articlesCount":1,`,
+		"blocked user's articles should be hidden from the blocker",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/", "GET", ``,
+		http.StatusOK, `"articlesCount":2,`,
+		"others should still see every article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments", "GET", ``,
+		http.StatusOK, `^{"comments":\[{"id":2,`,
+		"blocked user's comments should be hidden from the blocker",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/comments", "GET", ``,
+		http.StatusOK, `^{"comments":\[{"id":1,.*{"id":2,`,
+		"blocked user should still see the comments",
+	},
+	{
+		func(req *http.Request) {
+			test_db.Where("blocker_id = ?", 1).Delete(users.BlockModel{})
+			test_db.Create(&users.FollowModel{FollowingID: 2, FollowedByID: 1})
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/feed", "GET", ``,
+		http.StatusOK, `"slug":"by-user2",.*"articlesCount":1,`,
+		"followed user should be in the feed",
+	},
+	{
+		func(req *http.Request) {
+			test_db.Create(&users.MuteModel{MuterID: 1, MutedID: 2})
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/feed", "GET", ``,
+		http.StatusOK, `{"articles":\[\],"articlesCount":0,`,
+		"muted user should be hidden from the feed",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/?author=user2", "GET", ``,
+		http.StatusOK, `{"articles":\[\],"articlesCount":0,`,
+		"muted user's articles should be hidden from the muter",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments", "GET", ``,
+		http.StatusOK, `^{"comments":\[{"id":2,`,
+		"muted user's comments should be hidden from the muter",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/comments", "POST", `{"comment":{"body":"muted but here"}}`,
+		http.StatusCreated, `"body":"muted but here"`,
+		"muted user should still comment",
+	},
+}
+
+func TestBlocks(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	for _, testData := range blockRequestTests {
+		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(testData.bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+var privateAccountRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			var userModel users.UserModel
+			test_db.First(&userModel, 1)
+			CreateArticle(&ArticleModel{Title: "Private Thoughts", Author: GetArticleUserModel(userModel)})
+			test_db.Model(&userModel).Update("private", true)
+			test_db.Create(&BookmarkModel{UserID: 2, ArticleID: 1})
+		},
+		"/articles/", http.StatusOK, `{"articles":\[\],"articlesCount":0,`,
+		"private user's articles should be hidden from anonymous readers",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/?author=user1", http.StatusOK, `{"articles":\[\],"articlesCount":0,`,
+		"private user's articles should be hidden from other users",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/search?q=thoughts", http.StatusOK, `{"articles":\[\],"articlesCount":0}`,
+		"private user's articles should not be found, nor counted",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/bookmarks", http.StatusOK, `{"articles":\[\],"articlesCount":0,`,
+		"bookmarks should hide private user's articles too",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/", http.StatusOK, `"articlesCount":2,`,
+		"private user should see their own articles",
+	},
+	{
+		func(req *http.Request) {
+			test_db.Create(&users.FollowModel{FollowingID: 1, FollowedByID: 2})
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/", http.StatusOK, `"articlesCount":2,`,
+		"followers should see the private user's articles",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/feed", http.StatusOK, `"articlesCount":2,`,
+		"followers should have them in their feed",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/search?q=thoughts", http.StatusOK, `"slug":"private-thoughts"`,
+		"followers should find them",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/bookmarks", http.StatusOK, `"articlesCount":1,`,
+		"followers should have their bookmarks back",
+	},
+}
+
+func TestPrivateAccounts(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	UserArticlesRegister(r.Group("/user"))
+	for _, testData := range privateAccountRequestTests {
+		req, err := http.NewRequest("GET", testData.url, nil)
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+var notificationRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	bodyData       string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/favorite", "POST", ``,
+		http.StatusOK, `"favorited":true`,
+		"user2 favorites the article of user1",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/favorite", "POST", ``,
+		http.StatusOK, `"favorited":true`,
+		"favoriting again should not notify again",
+	},
+	{
+		func(req *http.Request) {
+			userModelMocker(1)
+			HeaderTokenMock(req, 3)
+		},
+		"/articles/hello-world/favorite", "POST", ``,
+		http.StatusOK, `"favorited":true`,
+		"user3 favorites it too",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/notifications", "GET", ``,
+		http.StatusOK, `"notifications":\[{"id":1,"kind":"favorite","message":"user3 and 1 other favorited your article \\"Hello World\\"","actor":{"username":"user3",[^}]*},"actorsCount":2,"article":{"slug":"hello-world","title":"Hello World"},"read":false,.*"notificationsCount":1,"prevCursor":null,"unreadCount":1}`,
+		"favorites should notify the author, gathered",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/hello-world/comments", "POST", `{"comment":{"body":"thanks"}}`,
+		http.StatusCreated, `"body":"thanks"`,
+		"author comments on their own article",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/articles/hello-world/comments", "POST", `{"comment":{"body":"nice"}}`,
+		http.StatusCreated, `"body":"nice"`,
+		"user2 comments",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/notifications", "GET", ``,
+		http.StatusOK, `"notifications":\[{"id":2,"kind":"comment","message":"user2 commented on your article \\"Hello World\\"",.*"actorsCount":1,.*{"id":1,"kind":"favorite",.*"notificationsCount":2,"prevCursor":null,"unreadCount":2}`,
+		"comments of others should notify the author",
+	},
+}
+
+func TestNotifications(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	users.UserRegister(r.Group("/user"))
+	for _, testData := range notificationRequestTests {
+		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(testData.bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+// Open the event stream at url on the server as the user (0 for nobody), resuming after
+// lastEventID if it isn't "". Cancel closes it.
+func openStream(t *testing.T, server *httptest.Server, url string, user uint, lastEventID string) (*bufio.Reader, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+url, nil)
+	if user != 0 {
+		HeaderTokenMock(req, user)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return bufio.NewReader(resp.Body), cancel
+}
+
+// The id, name and data of the next event of the stream, skipping heartbeats.
+func nextEvent(stream *bufio.Reader) (id, name, data string) {
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id:"):
+			id = line[3:]
+		case strings.HasPrefix(line, "event:"):
+			name = line[6:]
+		case strings.HasPrefix(line, "data:"):
+			data = line[5:]
+		case line == "" && name != "":
+			return
+		}
+	}
+}
+
+func TestCommentStream(t *testing.T) {
+	asserts := assert.New(t)
+	t.Setenv("HTTP_STREAM_HEARTBEAT", "50ms")
+	resetDBWithMock()
+	test_db.Create(&users.MuteModel{MuterID: 1, MutedID: 2})
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	server := httptest.NewServer(r)
+	defer server.Close()
+	comment := func(user uint, body string) {
+		req, _ := http.NewRequest("POST", "/articles/hello-world/comments", bytes.NewBufferString(`{"comment":{"body":"`+body+`"}}`))
+		req.Header.Set("Content-Type", "application/json")
+		HeaderTokenMock(req, user)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusCreated, w.Code)
+	}
+	topic := commentsTopic(1)
+
+	anonymous, closeAnonymous := openStream(t, server, "/articles/hello-world/comments/stream", 0, "")
+	muter, closeMuter := openStream(t, server, "/articles/hello-world/comments/stream", 1, "")
+	asserts.Equal(2, common.GetHub().Subscribers(topic))
+
+	comment(2, "live")
+	liveID, name, data := nextEvent(anonymous)
+	asserts.Equal("comment", name)
+	asserts.Regexp(`^{"id":3,"body":"live",.*"author":{"username":"user2",`, data, "new comment should be streamed")
+
+	comment(1, "mine")
+	_, _, data = nextEvent(muter)
+	asserts.Regexp(`"body":"mine"`, data, "comments of muted users should be left out")
+	_, _, data = nextEvent(anonymous)
+	asserts.Regexp(`"body":"mine"`, data)
+
+	line, _ := anonymous.ReadString('\n')
+	asserts.Equal(": heartbeat\n", line, "quiet stream should beat")
+
+	closeAnonymous()
+	closeMuter()
+	asserts.Eventually(func() bool { return common.GetHub().Subscribers(topic) == 0 }, time.Second, 10*time.Millisecond, "closed streams should unsubscribe")
+
+	resumed, closeResumed := openStream(t, server, "/articles/hello-world/comments/stream", 0, liveID)
+	defer closeResumed()
+	_, _, data = nextEvent(resumed)
+	asserts.Regexp(`"body":"mine"`, data, "reconnecting client should get what it missed")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/articles/nope/comments/stream", nil)
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusNotFound, w.Code, "unknown article should not be streamed")
+}
+
+func TestRenderBody(t *testing.T) {
+	asserts := assert.New(t)
+
+	tests := []struct {
+		body        string
+		html        string
+		toc         []TOCEntry
+		readingTime int
+		msg         string
+	}{
+		{"", "", []TOCEntry{}, 1, "empty body should still take a minute"},
+		{"Some *text*", "<p>Some <em>text</em></p>\n", []TOCEntry{}, 1, "Markdown should render"},
+		{
+			"# Intro\n\ntext\n\n## Going `further`\n", "<h1 id=\"intro\">Intro</h1>\n<p>text</p>\n<h2 id=\"going-further\">Going <code>further</code></h2>\n",
+			[]TOCEntry{{1, "Intro", "intro"}, {2, "Going further", "going-further"}}, 1,
+			"headings should get anchors and make the table of contents",
+		},
+		{
+			"# Café olé\n\n## Привет мир\n\n## 日本語\n\n## 日本語\n", "<h1 id=\"café-olé\">Café olé</h1>\n<h2 id=\"привет-мир\">Привет мир</h2>\n<h2 id=\"日本語\">日本語</h2>\n<h2 id=\"日本語-1\">日本語</h2>\n",
+			[]TOCEntry{{1, "Café olé", "café-olé"}, {2, "Привет мир", "привет-мир"}, {2, "日本語", "日本語"}, {2, "日本語", "日本語-1"}}, 1,
+			"non-ASCII headings should keep their letters in the anchors",
+		},
+		{"<script>alert(1)</script>\n\nok", "\n<p>ok</p>\n", []TOCEntry{}, 1, "raw HTML should be dropped"},
+		{"a <img src=x onerror=alert(1)> b", "<p>a  b</p>\n", []TOCEntry{}, 1, "inline HTML should be dropped"},
+		{"[x](javascript:alert(1))", "<p>x</p>\n", []TOCEntry{}, 1, "script links should not be links"},
+		{"[x](http://example.com)", "<p><a href=\"http://example.com\" rel=\"nofollow\">x</a></p>\n", []TOCEntry{}, 1, "links should be nofollow"},
+		{"```go\nx := 1\n```", "<pre><code class=\"language-go\">x := 1\n</code></pre>\n", []TOCEntry{}, 1, "code blocks should keep their language"},
+		{strings.Repeat("word ", 401), "", nil, 3, "reading time should round up"},
+	}
+	for _, test := range tests {
+		rendered := RenderBody(test.body)
+		if test.toc != nil {
+			asserts.Equal(test.html, rendered.HTML, test.msg)
+			asserts.Equal(test.toc, rendered.TOC, test.msg)
+		}
+		asserts.Equal(test.readingTime, rendered.ReadingTime, test.msg)
+		asserts.Equal(rendered, RenderBody(test.body), "rendering again should give the same result")
+	}
+}
+
+var markdownRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	bodyData       string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/", "POST", `{"article":{"title":"Rendered","body":"# Hello\n\n<script>alert(1)</script>"}}`,
+		http.StatusCreated, `"body":"# Hello\\n\\n\\u003cscript\\u003ealert\(1\)\\u003c/script\\u003e","createdAt"`,
+		"the body should be returned as written",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/rendered", "GET", ``,
+		http.StatusOK, `^{"article":{[^}]*"body":"# Hello.*"reactions":\[.*\],"bookmarked":false,"status":"published"}}$`,
+		"Markdown should be the default",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/rendered?format=html", "GET", ``,
+		http.StatusOK, `"body":"# Hello.*"bodyHtml":"\\u003ch1 id=\\"hello\\"\\u003eHello\\u003c/h1\\u003e\\n\\n","toc":\[{"level":1,"text":"Hello","id":"hello"}\],"readingTime":1}}`,
+		"format=html should add the sanitized HTML, table of contents and reading time",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/rendered?format=pdf", "GET", ``,
+		http.StatusUnprocessableEntity, `must be markdown or html`,
+		"unknown format should be rejected",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/articles/rendered?format=html", "PUT", `{"article":{"body":"## Changed"}}`,
+		http.StatusOK, `"bodyHtml":"\\u003ch2 id=\\"changed\\"\\u003eChanged\\u003c/h2\\u003e\\n","toc":\[{"level":2,"text":"Changed","id":"changed"}\]`,
+		"a new revision should render its own body",
+	},
+	{
+		func(req *http.Request) {},
+		"/articles/?format=html", "GET", ``,
+		http.StatusOK, `"bodyHtml":"\\u003ch2 id=\\"changed\\"`,
+		"lists should render too",
+	},
+}
+
+func TestMarkdown(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	for _, testData := range markdownRequestTests {
+		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(testData.bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+var queryCount int64
+var countQueriesOnce sync.Once
+
+// Count every statement gorm sends from now on, the callbacks are shared by all connections.
+func countQueries() {
+	countQueriesOnce.Do(func() {
+		count := func(scope *gorm.Scope) {
+			// Preloading many2many runs the query callbacks once per row without any SQL.
+			if scope.SQL != "" {
+				atomic.AddInt64(&queryCount, 1)
+			}
+		}
+		test_db.Callback().Query().After("gorm:query").Register("articles:count_queries", count)
+		test_db.Callback().RowQuery().After("gorm:row_query").Register("articles:count_queries", count)
+		test_db.Callback().Create().After("gorm:create").Register("articles:count_queries", count)
+		test_db.Callback().Update().After("gorm:update").Register("articles:count_queries", count)
+	})
+	atomic.StoreInt64(&queryCount, 0)
+}
+
+// n more articles by user1 with two tags each, all favorited by user2, who follows user1.
+func articlesMocker(n int) {
+	var userModels []users.UserModel
+	test_db.Order("id").Limit(2).Find(&userModels)
+	author := GetArticleUserModel(userModels[0])
+	reader := GetArticleUserModel(userModels[1])
+	test_db.Create(&users.FollowModel{FollowingID: 1, FollowedByID: 2})
+	for i := 0; i < n; i++ {
+		articleModel := ArticleModel{
+			Slug:        fmt.Sprintf("article-%v", i),
+			Title:       fmt.Sprintf("Article %v", i),
+			Description: "desc",
+			Body:        "body",
+			Author:      author,
+		}
+		articleModel.setTags([]string{"go", fmt.Sprintf("tag%v", i)})
+		test_db.Create(&articleModel)
+		test_db.Create(&FavoriteModel{FavoriteID: articleModel.ID, FavoriteByID: reader.ID})
+	}
+}
+
+func articleListRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(users.AuthMiddleware(false))
+	ArticlesAnonymousRegister(r.Group("/articles"))
+	return r
+}
+
+// How many queries a request for a page of articles takes, as user2.
+func articleListQueries(r *gin.Engine, url string) (int64, *httptest.ResponseRecorder) {
+	req, _ := http.NewRequest("GET", url, nil)
+	HeaderTokenMock(req, 2)
+	w := httptest.NewRecorder()
+	countQueries()
+	r.ServeHTTP(w, req)
+	return atomic.LoadInt64(&queryCount), w
+}
+
+func TestArticleListQueryCount(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	articlesMocker(20)
+	r := articleListRouter()
+
+	for _, url := range []string{"/articles/?limit=%v", "/articles/?tag=go&limit=%v", "/articles/?author=user1&limit=%v", "/articles/?favorited=user2&limit=%v", "/articles/feed?limit=%v"} {
+		one, w := articleListQueries(r, fmt.Sprintf(url, 1))
+		asserts.Equal(http.StatusOK, w.Code)
+		twenty, w := articleListQueries(r, fmt.Sprintf(url, 20))
+		asserts.Equal(http.StatusOK, w.Code)
+		asserts.Regexp(`"tagList":\["go","tag\d+"\],"favorited":true,"favoritesCount":1`, w.Body.String(), "preloaded fields should be filled - "+url)
+		asserts.Regexp(`"author":\{"username":"user1","bio":"bio1","image":null,"following":true\}`, w.Body.String(), "author should be preloaded - "+url)
+		asserts.Equal(one, twenty, "query count should not depend on the page size - "+url)
+	}
+}
+
+type pageResponse struct {
+	Articles []struct {
+		Slug string `json:"slug"`
+	} `json:"articles"`
+	Comments []struct {
+		ID uint `json:"id"`
+	} `json:"comments"`
+	ArticlesCount int     `json:"articlesCount"`
+	NextCursor    *string `json:"nextCursor"`
+	PrevCursor    *string `json:"prevCursor"`
+}
+
+func (p pageResponse) slugs() []string {
+	slugs := []string{}
+	for _, article := range p.Articles {
+		slugs = append(slugs, article.Slug)
+	}
+	return slugs
+}
+
+func TestCursorPagination(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	articlesMocker(5)
+	r := articleListRouter()
+
+	get := func(url string) (int, pageResponse) {
+		req, _ := http.NewRequest("GET", url, nil)
+		HeaderTokenMock(req, 2)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var page pageResponse
+		json.Unmarshal(w.Body.Bytes(), &page)
+		return w.Code, page
+	}
+
+	for _, url := range []string{"/articles/?limit=2", "/articles/feed?limit=2"} {
+		_, first := get(url)
+		asserts.Equal([]string{"article-4", "article-3"}, first.slugs(), "first page should have the newest articles - "+url)
+		asserts.Nil(first.PrevCursor, "first page should have no previous page - "+url)
+		asserts.NotNil(first.NextCursor)
+
+		test_db.Create(&ArticleModel{Slug: "late", Title: "Late", AuthorID: 1})
+		_, second := get(url + "&after=" + *first.NextCursor)
+		asserts.Equal([]string{"article-2", "article-1"}, second.slugs(), "new articles should not shift the next page - "+url)
+		asserts.NotNil(second.PrevCursor)
+
+		_, back := get(url + "&before=" + *second.PrevCursor)
+		asserts.Equal([]string{"article-4", "article-3"}, back.slugs(), "before should return the previous page - "+url)
+		asserts.NotNil(back.NextCursor)
+		asserts.NotNil(back.PrevCursor, "the new article is before the first page now - "+url)
+		test_db.Unscoped().Where(ArticleModel{Slug: "late"}).Delete(ArticleModel{})
+	}
+
+	_, last := get("/articles/?limit=4&offset=4")
+	asserts.Equal([]string{"article-0", "hello-world"}, last.slugs(), "offset mode should use the same order")
+	asserts.Equal(6, last.ArticlesCount)
+	asserts.Nil(last.NextCursor, "last page should have no next page")
+	asserts.NotNil(last.PrevCursor)
+
+	_, comments := get("/articles/hello-world/comments?limit=1")
+	asserts.Len(comments.Comments, 1)
+	asserts.Equal(uint(1), comments.Comments[0].ID, "comments should be oldest first")
+	_, comments = get("/articles/hello-world/comments?limit=1&after=" + *comments.NextCursor)
+	asserts.Equal(uint(2), comments.Comments[0].ID)
+	asserts.Nil(comments.NextCursor)
+
+	for i := 0; i < common.DefaultPageLimit+5; i++ {
+		test_db.Create(&CommentModel{ArticleID: 1, AuthorID: 2, Body: fmt.Sprint("comment ", i)})
+	}
+	_, comments = get("/articles/hello-world/comments")
+	asserts.Len(comments.Comments, common.DefaultPageLimit+7, "comments should all be returned when no page is asked for")
+	asserts.Nil(comments.NextCursor)
+	_, comments = get("/articles/hello-world/comments?offset=0")
+	asserts.Len(comments.Comments, common.DefaultPageLimit, "asking for a page should page with the default limit")
+
+	test_db.Model(&ArticleModel{}).Where("slug = ?", "article-1").UpdateColumn("updated_at", time.Now().Add(time.Hour))
+	_, feed := get("/articles/feed?limit=2")
+	asserts.Equal([]string{"article-1", "article-4"}, feed.slugs(), "feed should have the latest updated articles first")
+
+	for _, url := range []string{"/articles/?limit=0", "/articles/?limit=101", "/articles/?limit=x", "/articles/?offset=-1", "/articles/?after=nope", "/articles/?after=eyJpZCI6MX0&before=eyJpZCI6MX0", "/articles/feed?limit=x", "/articles/hello-world/comments?before=x"} {
+		code, _ := get(url)
+		asserts.Equal(http.StatusUnprocessableEntity, code, "bad page should be rejected - "+url)
+	}
+}
+
+// Besides hello-world, which is the newest: user1 writes "go-rust" (go, rust) in January,
+// user2 writes "go-only" (go) in February, favorited by user1, and "rust-only" (Rust) in
+// March, favorited by user2.
+func filterArticlesMocker() {
+	var userModels []users.UserModel
+	test_db.Order("id").Limit(2).Find(&userModels)
+	user1, user2 := GetArticleUserModel(userModels[0]), GetArticleUserModel(userModels[1])
+	mock := func(slug string, author ArticleUserModel, month time.Month, tags []string, favoriteBy ArticleUserModel) {
+		articleModel := ArticleModel{Slug: slug, Title: slug, Author: author}
+		articleModel.CreatedAt = time.Date(2024, month, 10, 0, 0, 0, 0, time.Local)
+		articleModel.setTags(tags)
+		test_db.Create(&articleModel)
+		if favoriteBy.ID != 0 {
+			test_db.Create(&FavoriteModel{FavoriteID: articleModel.ID, FavoriteByID: favoriteBy.ID})
+		}
+	}
+	mock("go-rust", user1, time.January, []string{"go", "rust"}, ArticleUserModel{})
+	mock("go-only", user2, time.February, []string{"go"}, user1)
+	mock("rust-only", user2, time.March, []string{"Rust"}, user2)
+}
+
+var articleFilterTests = []struct {
+	query        string
+	expectedCode int
+	slugs        []string
+	msg          string
+}{
+	{"", http.StatusOK, []string{"hello-world", "rust-only", "go-only", "go-rust"}, "list should be newest first"},
+	{"tag=go", http.StatusOK, []string{"go-only", "go-rust"}, "tag should filter"},
+	{"tag=go&tag=RUST", http.StatusOK, []string{"rust-only", "go-only", "go-rust"}, "several tags should match any of them"},
+	{"tag=go,rust&tagMatch=all", http.StatusOK, []string{"go-rust"}, "tagMatch=all should need every tag"},
+	{"tag=go&tag=go&tagMatch=all", http.StatusOK, []string{"go-only", "go-rust"}, "repeated tag should count once"},
+	{"tag=go&tag=missing&tagMatch=all", http.StatusOK, []string{}, "unknown tag should match nothing with all"},
+	{"tag=go&author=user2", http.StatusOK, []string{"go-only"}, "tag and author should compose"},
+	{"author=USER2&favorited=user2", http.StatusOK, []string{"rust-only"}, "author and favorited should compose"},
+	{"favorited=user1&tag=go", http.StatusOK, []string{"go-only"}, "favorited and tag should compose"},
+	{"author=nobody", http.StatusOK, []string{}, "unknown author should match nothing"},
+	{"createdAfter=2024-02-01&createdBefore=2024-03-01", http.StatusOK, []string{"go-only"}, "date range should filter"},
+	{"createdAfter=2024-02-10T12:00:00Z&tag=rust", http.StatusOK, []string{"rust-only"}, "date and tag should compose"},
+	{"tagMatch=some", http.StatusUnprocessableEntity, nil, "unknown tagMatch should be rejected"},
+	{"createdAfter=yesterday", http.StatusUnprocessableEntity, nil, "bad date should be rejected"},
+}
+
+func TestArticleFilters(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	filterArticlesMocker()
+	r := articleListRouter()
+
+	for _, testData := range articleFilterTests {
+		req, _ := http.NewRequest("GET", "/articles/?"+testData.query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		if testData.slugs != nil {
+			var page pageResponse
+			json.Unmarshal(w.Body.Bytes(), &page)
+			asserts.Equal(testData.slugs, page.slugs(), "Response Content - "+testData.msg)
+			asserts.Equal(len(testData.slugs), page.ArticlesCount, "Count - "+testData.msg)
+		}
+	}
+}
+
+// Articles for TestSearch by user1: "generics" is in the title of the first, the body of
+// the second and a comment on the third. The fourth is deleted and the fifth renamed.
+func searchArticlesMocker() {
+	var userModel users.UserModel
+	test_db.Order("id").First(&userModel)
+	author := GetArticleUserModel(userModel)
+	mock := func(title, body string, tags ...string) ArticleModel {
+		articleModel := ArticleModel{Title: title, Description: "desc", Body: body, Author: author}
+		articleModel.setTags(tags)
+		CreateArticle(&articleModel)
+		return articleModel
+	}
+	mock("Generics in Go", "Go finally has type parameters, see <script>", "golang")
+	mock("Rust ownership", "Borrowing explained, unlike generics in Go.", "rust")
+	cooking := mock("Cooking pasta", "Boil water.")
+	SaveOne(&CommentModel{ArticleID: cooking.ID, Author: author, Body: "reminds me of generics"})
+	mock("Deleted generics", "gone")
+	DeleteArticleModel(&ArticleModel{Slug: "deleted-generics"})
+	renamed := mock("Old title", "stripes")
+	renamed.Update(ArticleModel{Title: "Zebra crossing"})
+}
+
+type searchResponse struct {
+	Articles []struct {
+		Slug   string `json:"slug"`
+		Search struct {
+			Title   string `json:"title"`
+			Snippet string `json:"snippet"`
+		} `json:"search"`
+	} `json:"articles"`
+	ArticlesCount int `json:"articlesCount"`
+}
+
+var articleSearchTests = []struct {
+	query        string
+	expectedCode int
+	slugs        []string // the first one has to rank first, the rest in any order
+	msg          string
+}{
+	{"q=generics", http.StatusOK, []string{"generics-in-go", "rust-ownership", "cooking-pasta"}, "title should rank above body and comments"},
+	{"q=GENERICS+go!", http.StatusOK, []string{"generics-in-go", "rust-ownership"}, "every word should have to match"},
+	{"q=golang", http.StatusOK, []string{"generics-in-go"}, "tags should be searched"},
+	{"q=borrow", http.StatusOK, []string{"rust-ownership"}, "words should be stemmed"},
+	{"q=reminds", http.StatusOK, []string{"cooking-pasta"}, "comments should be searched"},
+	{"q=gone", http.StatusOK, []string{}, "deleted articles should not be found"},
+	{"q=zebra", http.StatusOK, []string{"zebra-crossing"}, "new title should be found"},
+	{"q=old", http.StatusOK, []string{}, "old title should be forgotten"},
+	{"q=generics&limit=1&offset=1", http.StatusOK, nil, "offset should page through the ranking"},
+	{"q=", http.StatusUnprocessableEntity, nil, "empty query should be rejected"},
+	{"q=%21%3F", http.StatusUnprocessableEntity, nil, "query without words should be rejected"},
+	{"q=go&after=eyJpZCI6MX0", http.StatusUnprocessableEntity, nil, "cursor should be rejected"},
+}
+
+// Search as the user, 0 searching anonymously.
+func searchAs(r *gin.Engine, user uint, query string) searchResponse {
+	req, _ := http.NewRequest("GET", "/articles/search?"+query, nil)
+	if user != 0 {
+		HeaderTokenMock(req, user)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var response searchResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response
+}
+
+func TestFavoriteDatabaseError(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	test_db.DropTable(&FavoriteModel{})
+	defer resetDBWithMock()
+
+	r := gin.New()
+	r.Use(users.AuthMiddleware(true))
+	ArticlesRegister(r.Group("/articles"))
+	for _, method := range []string{"POST", "DELETE"} {
+		req, _ := http.NewRequest(method, "/articles/hello-world/favorite", nil)
+		HeaderTokenMock(req, 2)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusUnprocessableEntity, w.Code, "database errors should not be ignored - "+method)
+		asserts.Equal(`{"errors":{"database":"no such table: favorite_models"}}`, w.Body.String())
+	}
+}
+
+func TestSearchVisibility(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	searchArticlesMocker()
+	var userModel users.UserModel
+	test_db.First(&userModel, 2)
+	CreateArticle(&ArticleModel{Title: "Generics for everyone", Author: GetArticleUserModel(userModel)})
+	r := articleListRouter()
+
+	test_db.Model(&users.UserModel{ID: 1}).Update("private", true)
+	response := searchAs(r, 0, "q=generics&limit=1")
+	asserts.Equal(1, response.ArticlesCount, "private user's articles should not be counted")
+	if asserts.Len(response.Articles, 1, "pages should not come back short") {
+		asserts.Equal("generics-for-everyone", response.Articles[0].Slug)
+	}
+	asserts.Equal(4, searchAs(r, 1, "q=generics").ArticlesCount, "private user should find their own articles")
+
+	test_db.Model(&users.UserModel{ID: 1}).Update("private", false)
+	test_db.Create(&users.MuteModel{MuterID: 1, MutedID: 2})
+	test_db.Create(&users.BlockModel{BlockerID: 2, BlockedID: 1})
+	asserts.Equal(3, searchAs(r, 1, "q=generics").ArticlesCount, "muted users' articles should not be found")
+	response = searchAs(r, 2, "q=generics&limit=1")
+	asserts.Equal(1, response.ArticlesCount, "blocked users' articles should not be found")
+	if asserts.Len(response.Articles, 1) {
+		asserts.Equal("generics-for-everyone", response.Articles[0].Slug)
+	}
+	asserts.Equal(4, searchAs(r, 3, "q=generics").ArticlesCount, "others should find them all")
+}
+
+func TestSearch(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	searchArticlesMocker()
+	r := articleListRouter()
+
+	get := func(query string) (int, searchResponse) {
+		req, _ := http.NewRequest("GET", "/articles/search?"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var response searchResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+	slugs := func(response searchResponse) []string {
+		slugs := []string{}
+		for _, article := range response.Articles {
+			slugs = append(slugs, article.Slug)
+		}
+		return slugs
+	}
+
+	for _, testData := range articleSearchTests {
+		code, response := get(testData.query)
+		asserts.Equal(testData.expectedCode, code, "Response Status - "+testData.msg)
+		if testData.slugs == nil {
+			continue
+		}
+		asserts.ElementsMatch(testData.slugs, slugs(response), "Response Content - "+testData.msg)
+		if len(testData.slugs) > 1 && len(response.Articles) > 0 {
+			asserts.Equal(testData.slugs[0], response.Articles[0].Slug, "Ranking - "+testData.msg)
+		}
+		asserts.Equal(len(testData.slugs), response.ArticlesCount, "Count - "+testData.msg)
+	}
+
+	_, all := get("q=generics")
+	_, second := get("q=generics&limit=1&offset=1")
+	asserts.Equal(3, second.ArticlesCount, "count should not depend on the page")
+	asserts.Equal(slugs(all)[1:2], slugs(second), "offset should page through the ranking")
+
+	_, response := get("q=generics")
+	asserts.Equal("<mark>Generics</mark> in Go", response.Articles[0].Search.Title, "title should be highlighted")
+	_, response = get("q=script")
+	asserts.Contains(response.Articles[0].Search.Snippet, "&lt;<mark>script</mark>&gt;", "snippet should be escaped and highlighted")
+
+	DeleteCommentModel(&CommentModel{Body: "reminds me of generics"})
+	_, response = get("q=reminds")
+	asserts.Empty(response.Articles, "deleted comments should not be found")
+
+	searchArticle := ArticleModel{Title: "Search", Author: GetArticleUserModel(users.UserModel{})}
+	CreateArticle(&searchArticle)
+	asserts.Equal("search-2", searchArticle.Slug, "slugs of routes should not be taken")
+}
+
+// go test ./articles -run XXX -bench ArticleList reports queries/op per page size,
+// which should be the same for every size.
+func BenchmarkArticleList(b *testing.B) {
+	resetDBWithMock()
+	articlesMocker(50)
+	test_db.LogMode(false)
+	defer test_db.LogMode(true)
+	r := articleListRouter()
+
+	for _, size := range []int{1, 10, 50} {
+		b.Run(fmt.Sprintf("limit=%v", size), func(b *testing.B) {
+			var queries int64
+			for i := 0; i < b.N; i++ {
+				count, _ := articleListQueries(r, fmt.Sprintf("/articles/?limit=%v", size))
+				queries += count
+			}
+			b.ReportMetric(float64(queries)/float64(b.N), "queries/op")
+		})
+	}
+}
+
+//Each package gets its own database file, so `go test ./...` can run packages in parallel.
+func TestMain(m *testing.M) {
+	common.TestDBPath = "./../gorm_articles_test.db"
+	test_db = common.TestDBInit()
+	users.AutoMigrate()
+	AutoMigrate()
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
@@ -1,7 +1,9 @@
 package articles
 
 import (
-	"github.com/gosimple/slug"
+	"strings"
+	"time"
+
 	"realworld-backend/common"
 	"realworld-backend/users"
 	"github.com/gin-gonic/gin"
@@ -13,6 +15,9 @@ type ArticleModelValidator struct {
 		Description string   `form:"description" json:"description" binding:"max=2048"`
 		Body        string   `form:"body" json:"body" binding:"max=2048"`
 		Tags        []string `form:"tagList" json:"tagList"`
+		// published by default, scheduled needs a publishAt
+		Status    string     `form:"status" json:"status" binding:"omitempty,oneof=draft scheduled published"`
+		PublishAt *time.Time `form:"publishAt" json:"publishAt" binding:"required_if=Status scheduled"`
 	} `json:"article"`
 	articleModel ArticleModel `json:"-"`
 }
@@ -26,6 +31,8 @@ func NewArticleModelValidatorFillWith(articleModel ArticleModel) ArticleModelVal
 	articleModelValidator.Article.Title = articleModel.Title
 	articleModelValidator.Article.Description = articleModel.Description
 	articleModelValidator.Article.Body = articleModel.Body
+	articleModelValidator.Article.Status = articleModel.Status
+	articleModelValidator.Article.PublishAt = articleModel.PublishAt
 	for _, tagModel := range articleModel.Tags {
 		articleModelValidator.Article.Tags = append(articleModelValidator.Article.Tags, tagModel.Tag)
 	}
@@ -39,10 +46,16 @@ func (s *ArticleModelValidator) Bind(c *gin.Context) error {
 	if err != nil {
 		return err
 	}
-	s.articleModel.Slug = slug.Make(s.Article.Title)
 	s.articleModel.Title = s.Article.Title
 	s.articleModel.Description = s.Article.Description
 	s.articleModel.Body = s.Article.Body
+	s.articleModel.Status = s.Article.Status
+	if s.articleModel.Status == "" {
+		s.articleModel.Status = ArticlePublished
+	}
+	if s.articleModel.Status == ArticleScheduled {
+		s.articleModel.PublishAt = s.Article.PublishAt
+	}
 	s.articleModel.Author = GetArticleUserModel(myUserModel)
 	s.articleModel.setTags(s.Article.Tags)
 	return nil
@@ -50,7 +63,8 @@ func (s *ArticleModelValidator) Bind(c *gin.Context) error {
 
 type CommentModelValidator struct {
 	Comment struct {
-		Body string `form:"body" json:"body" binding:"max=2048"`
+		Body     string `form:"body" json:"body" binding:"max=2048"`
+		ParentID *uint  `form:"parentId" json:"parentId"`
 	} `json:"comment"`
 	commentModel CommentModel `json:"-"`
 }
@@ -70,3 +84,92 @@ func (s *CommentModelValidator) Bind(c *gin.Context) error {
 	s.commentModel.Author = GetArticleUserModel(myUserModel)
 	return nil
 }
+
+// The body of a bookmark is optional, without one the article goes in no folder.
+type BookmarkValidator struct {
+	Bookmark struct {
+		Folder string `form:"folder" json:"folder" binding:"max=64"`
+	} `json:"bookmark"`
+}
+
+func (s *BookmarkValidator) Bind(c *gin.Context) error {
+	if c.Request.ContentLength != 0 {
+		if err := common.Bind(c, s); err != nil {
+			return err
+		}
+	}
+	s.Bookmark.Folder = strings.TrimSpace(s.Bookmark.Folder)
+	return nil
+}
+
+// The query string of the article list:
+//
+//	?tag=go&tag=rust&tagMatch=all    or ?tag=go,rust, tagMatch is any by default
+//	&author=jake&favorited=jane
+//	&createdAfter=2024-01-01&createdBefore=2024-02-01T12:00:00Z
+type ArticleFilterValidator struct {
+	Tags          []string `form:"tag"`
+	TagMatch      string   `form:"tagMatch" binding:"omitempty,oneof=any all"`
+	Author        string   `form:"author"`
+	Favorited     string   `form:"favorited"`
+	CreatedAfter  string   `form:"createdAfter" binding:"omitempty,datetime=2006-01-02T15:04:05Z07:00|datetime=2006-01-02"`
+	CreatedBefore string   `form:"createdBefore" binding:"omitempty,datetime=2006-01-02T15:04:05Z07:00|datetime=2006-01-02"`
+	filter        ArticleFilter
+}
+
+func NewArticleFilterValidator() ArticleFilterValidator {
+	return ArticleFilterValidator{}
+}
+
+func (s *ArticleFilterValidator) Bind(c *gin.Context) error {
+	err := c.ShouldBindQuery(s)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, tags := range s.Tags {
+		for _, tag := range strings.Split(tags, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				s.filter.Tags = append(s.filter.Tags, tag)
+			}
+		}
+	}
+	s.filter.AllTags = s.TagMatch == "all"
+	s.filter.Author = s.Author
+	s.filter.Favorited = s.Favorited
+	s.filter.CreatedAfter = parseFilterTime(s.CreatedAfter)
+	s.filter.CreatedBefore = parseFilterTime(s.CreatedBefore)
+	return nil
+}
+
+// Already validated, so one of the layouts matches. It is compared in local time, which is
+// how the timestamps are written, as SQLite compares them as text.
+func parseFilterTime(value string) time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Local()
+		}
+	}
+	return time.Time{}
+}
+
+// The query string of the article search, ?q=golang+generics&limit=10&offset=10.
+type ArticleSearchValidator struct {
+	Query string `form:"q" binding:"required,max=256"`
+	terms []string
+}
+
+func NewArticleSearchValidator() ArticleSearchValidator {
+	return ArticleSearchValidator{}
+}
+
+func (s *ArticleSearchValidator) Bind(c *gin.Context) error {
+	err := c.ShouldBindQuery(s)
+	if err != nil {
+		return err
+	}
+	s.terms = searchTerms(s.Query)
+	return nil
+}
@@ -3,7 +3,6 @@ package common
 import (
 	"fmt"
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"os"
 )
 
@@ -13,21 +12,40 @@ type Database struct {
 
 var DB *gorm.DB
 
+// Where TestDBInit puts the temporarily database. Packages whose tests may run at the
+// same time as another package's should point it to their own file in TestMain.
+var TestDBPath = "./../gorm_test.db"
+
 // Opening a database and save the reference to `Database` struct.
+// Which one comes from GetConfig().Database, the dialect is picked by the DSN.
 func Init() *gorm.DB {
-	db, err := gorm.Open("sqlite3", "./../gorm.db")
+	cfg := GetConfig().Database
+	dialect, source, err := cfg.Source()
+	if err != nil {
+		fmt.Println("db err: (Init) ", err)
+		dialect, source = "sqlite3", cfg.Path
+	}
+	db, err := gorm.Open(dialect, source)
 	if err != nil {
 		fmt.Println("db err: (Init) ", err)
 	}
-	db.DB().SetMaxIdleConns(10)
+	db.DB().SetMaxIdleConns(cfg.MaxIdleConns)
 	//db.LogMode(true)
 	DB = db
 	return DB
 }
 
-// This function will create a temporarily database for running testing cases
+// This function will create a temporarily database for running testing cases.
+// Set TEST_DATABASE_URL to run them against another dialect, e.g. a local Postgres.
 func TestDBInit() *gorm.DB {
-	test_db, err := gorm.Open("sqlite3", "./../gorm_test.db")
+	dialect, source := "sqlite3", TestDBPath
+	if dsn := os.Getenv("TEST_DATABASE_URL"); dsn != "" {
+		var err error
+		if dialect, source, err = ParseDSN(dsn); err != nil {
+			fmt.Println("db err: (TestDBInit) ", err)
+		}
+	}
+	test_db, err := gorm.Open(dialect, source)
 	if err != nil {
 		fmt.Println("db err: (TestDBInit) ", err)
 	}
@@ -39,8 +57,13 @@ func TestDBInit() *gorm.DB {
 
 // Delete the database after running testing cases.
 func TestDBFree(test_db *gorm.DB) error {
+	if test_db.Dialect().GetName() != "sqlite3" {
+		err := dropAllTables(test_db)
+		test_db.Close()
+		return err
+	}
 	test_db.Close()
-	err := os.Remove("./../gorm_test.db")
+	err := os.Remove(TestDBPath)
 	return err
 }
 
@@ -0,0 +1,171 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// Something that happened, for the subscribers of its topic. IDs grow across topics, so a
+// client reconnecting with the last one it saw gets whatever it missed of its topic.
+type Event struct {
+	ID    uint64
+	Topic string
+	Name  string
+	Data  interface{} // the model, each stream serializes it for its own user
+}
+
+// How many of the latest events, of all topics, are kept for reconnecting clients.
+const hubHistory = 1024
+
+// How many events a subscriber may fall behind before it is dropped. Its client reconnects
+// and catches up from history.
+const subscriptionBuffer = 32
+
+type Subscription struct {
+	Events <-chan Event // closed once unsubscribed, or dropped for being too slow
+	events chan Event
+	topic  string
+}
+
+// An in-process publish/subscribe hub. Every instance of the server has its own, events
+// published on one instance only reach the clients connected to it.
+type Hub struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []Event // oldest first
+	subscribers map[string]map[*Subscription]bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[string]map[*Subscription]bool)}
+}
+
+var hub = NewHub()
+
+// The hub of the server.
+func GetHub() *Hub {
+	return hub
+}
+
+// Send the event to the current subscribers of the topic and keep it for those reconnecting.
+//
+//	common.GetHub().Publish("article/1/comments", "comment", commentModel)
+func (h *Hub) Publish(topic, name string, data interface{}) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastID++
+	event := Event{ID: h.lastID, Topic: topic, Name: name, Data: data}
+	h.history = append(h.history, event)
+	if len(h.history) > hubHistory {
+		h.history = h.history[len(h.history)-hubHistory:]
+	}
+	for subscription := range h.subscribers[topic] {
+		select {
+		case subscription.events <- event:
+		default:
+			h.remove(subscription)
+		}
+	}
+	return event
+}
+
+// Subscribe to the topic. The events of the topic published after lastID, as far as they are
+// still kept, come first; 0 starts with the next event.
+func (h *Hub) Subscribe(topic string, lastID uint64) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var missed []Event
+	if lastID != 0 {
+		for _, event := range h.history {
+			if event.ID > lastID && event.Topic == topic {
+				missed = append(missed, event)
+			}
+		}
+	}
+	events := make(chan Event, subscriptionBuffer+len(missed))
+	for _, event := range missed {
+		events <- event
+	}
+	subscription := &Subscription{Events: events, events: events, topic: topic}
+	if h.subscribers[topic] == nil {
+		h.subscribers[topic] = make(map[*Subscription]bool)
+	}
+	h.subscribers[topic][subscription] = true
+	return subscription
+}
+
+// Stop sending to the subscription and close its channel, unsubscribing twice is fine.
+func (h *Hub) Unsubscribe(subscription *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(subscription)
+}
+
+// How many subscribers the topic has.
+func (h *Hub) Subscribers(topic string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers[topic])
+}
+
+func (h *Hub) remove(subscription *Subscription) {
+	subscribers := h.subscribers[subscription.topic]
+	if !subscribers[subscription] {
+		return
+	}
+	delete(subscribers, subscription)
+	if len(subscribers) == 0 {
+		delete(h.subscribers, subscription.topic)
+	}
+	close(subscription.events)
+}
+
+// Stream the events of the topic to the client as server-sent events until it goes away,
+// from its Last-Event-ID on when it reconnects. render turns an event into the data this
+// client gets, false skips the event. Between events a heartbeat comment goes out every
+// http.stream_heartbeat.
+//
+//	common.Stream(c, topic, func(event common.Event) (interface{}, bool) { ... })
+func Stream(c *gin.Context, topic string, render func(Event) (interface{}, bool)) {
+	lastID, _ := strconv.ParseUint(c.GetHeader("Last-Event-ID"), 10, 64)
+	subscription := GetHub().Subscribe(topic, lastID)
+	defer GetHub().Unsubscribe(subscription)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // nginx would hold the events back otherwise
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(time.Duration(GetConfig().HTTP.StreamHeartbeat))
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-subscription.Events:
+			if !ok {
+				return
+			}
+			data, ok := render(event)
+			if !ok {
+				continue
+			}
+			if err := sse.Encode(c.Writer, sse.Event{Id: fmt.Sprint(event.ID), Event: event.Name, Data: data}); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,448 @@
+package common
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+)
+
+// A key access tokens are signed with. The newest one signs, the ones it replaced keep
+// verifying until RetiredAt + jwt.rotation_grace, so tokens issued just before a rotation
+// stay valid. Rows are shared through the database, so every instance signs with the
+// same key and publishes the same JWKS.
+//
+// PrivateKey is PKCS #8 PEM, in the same database as the users. With jwt.key_secret it is
+// sealed with AES-GCM first, so a copy of the database alone can't sign tokens. Every
+// instance needs the same secret, keys sealed with another one are skipped.
+type SigningKeyModel struct {
+	ID         uint   `gorm:"primary_key"`
+	KID        string `gorm:"column:kid;unique_index"`
+	Algorithm  string
+	PrivateKey string `gorm:"size:4096"`
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+
+	signer crypto.Signer
+}
+
+// One entry of /.well-known/jwks.json, see RFC 7517 and RFC 8037 for the OKP one.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// jwt-go v3 has no EdDSA, this is the Ed25519 one from RFC 8037.
+type signingMethodEdDSA struct{}
+
+func (signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func init() {
+	jwt.RegisterSigningMethod("EdDSA", func() jwt.SigningMethod { return signingMethodEdDSA{} })
+}
+
+// How often the keys are reloaded from the database: when signing, to pick up the rotations
+// of other instances, and at most that often for an unknown kid, so tokens with made up
+// kids can't turn into a query each.
+const keyReloadInterval = 10 * time.Second
+
+type keyring struct {
+	sync.Mutex
+	keys     []SigningKeyModel // newest first
+	loadedAt time.Time
+}
+
+var signingKeys keyring
+
+// Sign claims with the current key, rotating it first when it is due.
+// In HS256 mode the token is signed with jwt.secret and has no kid.
+func signToken(claims jwt.MapClaims) (string, error) {
+	cfg := GetConfig().JWT
+	if cfg.Algorithm == "HS256" {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.Secret))
+	}
+	key, err := signingKeys.current(cfg, time.Now())
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.KID
+	return token.SignedString(key.signer)
+}
+
+// The jwt.Keyfunc verifying access tokens: the key named by the kid header, which has to
+// be one of ours, not past its grace window and of the algorithm the token claims.
+//
+//	token, err := jwt.Parse(tokenString, common.VerificationKey)
+func VerificationKey(token *jwt.Token) (interface{}, error) {
+	cfg := GetConfig().JWT
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if cfg.Algorithm != "HS256" || token.Method.Alg() != "HS256" {
+			return nil, errors.New("token has no kid")
+		}
+		return []byte(cfg.Secret), nil
+	}
+	key, ok := signingKeys.find(cfg, kid, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("signing key %q is not %v", kid, token.Method.Alg())
+	}
+	return key.signer.Public(), nil
+}
+
+// Replace the current signing key now instead of waiting for jwt.rotation_interval,
+// e.g. when it may have leaked. Tokens signed by the old key are still accepted during
+// the grace window.
+func RotateSigningKey() error {
+	cfg := GetConfig().JWT
+	if cfg.Algorithm == "HS256" {
+		return errors.New("HS256 tokens are signed with jwt.secret, there is no key to rotate")
+	}
+	signingKeys.Lock()
+	defer signingKeys.Unlock()
+	return signingKeys.rotate(cfg, time.Now())
+}
+
+// The public half of every key that is still accepted, what /.well-known/jwks.json serves.
+func JWKS() JSONWebKeySet {
+	cfg := GetConfig().JWT
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	if cfg.Algorithm == "HS256" {
+		return set
+	}
+	if _, err := signingKeys.current(cfg, time.Now()); err != nil {
+		fmt.Println("jwks err: ", err)
+	}
+	signingKeys.Lock()
+	defer signingKeys.Unlock()
+	now := time.Now()
+	for _, key := range signingKeys.keys {
+		if key.accepted(cfg, now) {
+			set.Keys = append(set.Keys, key.jwk())
+		}
+	}
+	return set
+}
+
+// Remove keys whose grace window is over, they are never used again.
+func PurgeRetiredSigningKeys() error {
+	cfg := GetConfig().JWT
+	return GetDB().Where("retired_at < ?", time.Now().Add(-time.Duration(cfg.RotationGrace))).Delete(SigningKeyModel{}).Error
+}
+
+func (k *keyring) current(cfg JWTConfig, now time.Time) (SigningKeyModel, error) {
+	k.Lock()
+	defer k.Unlock()
+	if len(k.keys) == 0 || now.Sub(k.loadedAt) >= keyReloadInterval {
+		k.load(cfg, now)
+	}
+	if k.due(cfg, now) {
+		// Another instance may have rotated already.
+		k.load(cfg, now)
+		if k.due(cfg, now) {
+			if err := k.rotate(cfg, now); err != nil {
+				return SigningKeyModel{}, err
+			}
+		}
+	}
+	return k.keys[0], nil
+}
+
+func (k *keyring) due(cfg JWTConfig, now time.Time) bool {
+	if len(k.keys) == 0 || k.keys[0].RetiredAt != nil || k.keys[0].Algorithm != cfg.Algorithm {
+		return true
+	}
+	return now.Sub(k.keys[0].CreatedAt) >= time.Duration(cfg.RotationInterval)
+}
+
+func (k *keyring) find(cfg JWTConfig, kid string, now time.Time) (SigningKeyModel, bool) {
+	k.Lock()
+	defer k.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		for _, key := range k.keys {
+			if key.KID == kid {
+				return key, key.accepted(cfg, now)
+			}
+		}
+		if attempt == 0 && now.Sub(k.loadedAt) >= keyReloadInterval {
+			k.load(cfg, now)
+		} else {
+			break
+		}
+	}
+	return SigningKeyModel{}, false
+}
+
+// Refresh the keys from the database. Keys which only live in memory, because there is
+// no database or saving them failed, are kept so the tokens they signed stay valid.
+func (k *keyring) load(cfg JWTConfig, now time.Time) {
+	k.loadedAt = now
+	db := GetDB()
+	if db == nil {
+		return
+	}
+	var rows []SigningKeyModel
+	err := db.Where("retired_at IS NULL OR retired_at > ?", now.Add(-time.Duration(cfg.RotationGrace))).
+		Order("created_at desc, id desc").Find(&rows).Error
+	if err != nil {
+		fmt.Println("signing keys err: (load) ", err)
+		return
+	}
+	var keys []SigningKeyModel
+	loaded := make(map[string]bool)
+	for _, row := range rows {
+		if err := row.parse(cfg); err != nil {
+			fmt.Println("signing keys err: (load) ", row.KID, err)
+			continue
+		}
+		keys = append(keys, row)
+		loaded[row.KID] = true
+	}
+	for _, key := range k.keys {
+		if !loaded[key.KID] {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	k.keys = keys
+}
+
+// Make a new current key and retire the others, the caller holds the lock. When another
+// instance replaced the current key first, its key is loaded and becomes current instead.
+func (k *keyring) rotate(cfg JWTConfig, now time.Time) error {
+	key, err := newSigningKey(cfg, now)
+	if err != nil {
+		return err
+	}
+	if db := GetDB(); db != nil {
+		replaced, err := k.save(db, key, now)
+		if err != nil {
+			fmt.Println("signing keys err: (rotate) ", err)
+		} else if !replaced {
+			k.load(cfg, now)
+			return nil
+		}
+	}
+	for i := range k.keys {
+		if k.keys[i].RetiredAt == nil {
+			retiredAt := now
+			k.keys[i].RetiredAt = &retiredAt
+		}
+	}
+	k.keys = append([]SigningKeyModel{key}, k.keys...)
+	return nil
+}
+
+// Store key as the successor of the current key. Instances due at the same time race to
+// retire the same current key, the row lock lets one of them win: the others get false and
+// save nothing, so no instance signs with a key another one retired.
+func (k *keyring) save(db *gorm.DB, key SigningKeyModel, now time.Time) (bool, error) {
+	tx := db.Begin()
+	if len(k.keys) > 0 && k.keys[0].RetiredAt == nil {
+		current := k.keys[0].KID
+		result := tx.Model(&SigningKeyModel{}).Where("kid = ? AND retired_at IS NULL", current).Update("retired_at", now)
+		if result.Error != nil {
+			tx.Rollback()
+			return false, result.Error
+		}
+		if result.RowsAffected == 0 {
+			// Retired by another instance, unless it only ever was in memory
+			var stored int
+			tx.Model(&SigningKeyModel{}).Where("kid = ?", current).Count(&stored)
+			if stored != 0 {
+				tx.Rollback()
+				return false, nil
+			}
+		}
+	}
+	err := tx.Create(&key).Error
+	if err == nil {
+		// Left over from instances starting together, or from another algorithm
+		err = tx.Model(&SigningKeyModel{}).Where("retired_at IS NULL AND kid <> ?", key.KID).Update("retired_at", now).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit().Error
+}
+
+func newSigningKey(cfg JWTConfig, now time.Time) (SigningKeyModel, error) {
+	var signer crypto.Signer
+	var err error
+	algorithm := cfg.Algorithm
+	switch algorithm {
+	case "RS256":
+		signer, err = rsa.GenerateKey(crand.Reader, 2048)
+	case "EdDSA":
+		_, signer, err = ed25519.GenerateKey(crand.Reader)
+	default:
+		err = fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return SigningKeyModel{}, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return SigningKeyModel{}, err
+	}
+	privateKey := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if cfg.KeySecret != "" {
+		if privateKey, err = sealPrivateKey(privateKey, cfg.KeySecret); err != nil {
+			return SigningKeyModel{}, err
+		}
+	}
+	return SigningKeyModel{
+		KID:        RandToken(12),
+		Algorithm:  algorithm,
+		PrivateKey: privateKey,
+		CreatedAt:  now,
+		signer:     signer,
+	}, nil
+}
+
+// Sealed private keys start with this, the rest is the base64 of the nonce and the
+// ciphertext. Keys stored before jwt.key_secret was set are plain PEM and still load.
+const sealedKeyPrefix = "aes-gcm:"
+
+// AES-256-GCM with the SHA-256 of the secret as the key.
+func keyCipher(secret string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealPrivateKey(privateKey, secret string) (string, error) {
+	aead, err := keyCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := crand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(privateKey), nil)
+	return sealedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openPrivateKey(stored, secret string) (string, error) {
+	if !strings.HasPrefix(stored, sealedKeyPrefix) {
+		return stored, nil
+	}
+	if secret == "" {
+		return "", errors.New("private key is sealed and jwt.key_secret is not set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealedKeyPrefix))
+	if err != nil {
+		return "", err
+	}
+	aead, err := keyCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("private key is too short")
+	}
+	opened, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("private key was sealed with another jwt.key_secret")
+	}
+	return string(opened), nil
+}
+
+func (key *SigningKeyModel) parse(cfg JWTConfig) error {
+	privateKey, err := openPrivateKey(key.PrivateKey, cfg.KeySecret)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return errors.New("private key is not PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return errors.New("private key can not sign")
+	}
+	key.signer = signer
+	return nil
+}
+
+func (key SigningKeyModel) accepted(cfg JWTConfig, now time.Time) bool {
+	return key.RetiredAt == nil || now.Before(key.RetiredAt.Add(time.Duration(cfg.RotationGrace)))
+}
+
+func (key SigningKeyModel) jwk() JSONWebKey {
+	jwk := JSONWebKey{KeyID: key.KID, Use: "sig", Algorithm: key.Algorithm}
+	switch public := key.signer.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
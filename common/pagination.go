@@ -0,0 +1,195 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// Which part of a list to return. A cursor names the row a page ends at, so it stays on
+// the same row whatever gets inserted or deleted meanwhile:
+//
+//	?limit=20&offset=40          offset mode, as the RealWorld spec has it
+//	?limit=20&after=<nextCursor> the page after the last one
+//	?limit=20&before=<prevCursor> the page before the first one
+//
+// A Limit of 0 is the whole list.
+type Page struct {
+	Limit  int
+	Offset int
+	After  *Cursor
+	Before *Cursor
+}
+
+// The cursors of the pages around the one returned, nil when there is no such page.
+type Cursors struct {
+	Next *string `json:"nextCursor"`
+	Prev *string `json:"prevCursor"`
+}
+
+// Where a page ends: the id of the row and, when the list is sorted by a time column, its value.
+type Cursor struct {
+	ID uint      `json:"id"`
+	At time.Time `json:"at,omitzero"`
+}
+
+// Read limit, offset, after and before from the query string. Bad values are an error
+// rather than silently replaced, the key says which parameter is wrong.
+func ParsePage(c *gin.Context) (Page, string, error) {
+	page := Page{Limit: DefaultPageLimit}
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if page.Limit, err = strconv.Atoi(limit); err != nil || page.Limit < 1 || page.Limit > MaxPageLimit {
+			return page, "limit", fmt.Errorf("should be between 1 and %v", MaxPageLimit)
+		}
+	}
+	if offset := c.Query("offset"); offset != "" {
+		if page.Offset, err = strconv.Atoi(offset); err != nil || page.Offset < 0 {
+			return page, "offset", errors.New("should not be negative")
+		}
+	}
+	after, before := c.Query("after"), c.Query("before")
+	if after != "" && before != "" {
+		return page, "cursor", errors.New("after and before can not be used together")
+	}
+	if after != "" {
+		if page.After, err = decodeCursor(after); err != nil {
+			return page, "after", err
+		}
+	}
+	if before != "" {
+		if page.Before, err = decodeCursor(before); err != nil {
+			return page, "before", err
+		}
+	}
+	if page.Offset != 0 && (page.After != nil || page.Before != nil) {
+		return page, "offset", errors.New("can not be used with a cursor")
+	}
+	return page, "", nil
+}
+
+// Whether the query string asks for a page at all. Lists the RealWorld spec returns whole
+// stay whole without it.
+func Paged(c *gin.Context) bool {
+	for _, param := range []string{"limit", "offset", "after", "before"} {
+		if c.Query(param) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeCursor(cursor Cursor) *string {
+	data, _ := json.Marshal(cursor)
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	return &encoded
+}
+
+func decodeCursor(encoded string) (*Cursor, error) {
+	var cursor Cursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil || cursor.ID == 0 {
+		return nil, errors.New("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+// Load the page of query into dest, a pointer to a slice of models with an ID field, sorted
+// by the time or *time.Time field sortBy ("" sorts by id alone) then id, newest first when
+// desc. It returns the cursors of the neighbouring pages.
+//
+//	cursors, err := page.Find(db.Model(&ArticleModel{}), &models, "CreatedAt", true)
+func (p Page) Find(query *gorm.DB, dest interface{}, sortBy string, desc bool) (Cursors, error) {
+	var cursors Cursors
+	table := query.NewScope(dest).TableName()
+	id := table + ".id"
+	column := id
+	if sortBy != "" {
+		column = table + "." + gorm.ToColumnName(sortBy)
+	}
+	forward, backward := "ASC", "DESC"
+	later, earlier := ">", "<"
+	if desc {
+		forward, backward = backward, forward
+		later, earlier = earlier, later
+	}
+	// Rows past the cursor in the given direction, ties on the sort column are broken by id.
+	past := func(cursor *Cursor, op string) *gorm.DB {
+		if sortBy == "" {
+			return query.Where(fmt.Sprintf("%v %v ?", id, op), cursor.ID)
+		}
+		return query.Where(fmt.Sprintf("%v %v ? OR (%v = ? AND %v %v ?)", column, op, column, id, op), cursor.At, cursor.At, cursor.ID)
+	}
+	order := func(direction string) string {
+		if sortBy == "" {
+			return id + " " + direction
+		}
+		return fmt.Sprintf("%v %v, %v %v", column, direction, id, direction)
+	}
+	switch {
+	case p.After != nil:
+		query = past(p.After, later).Order(order(forward))
+	case p.Before != nil:
+		query = past(p.Before, earlier).Order(order(backward))
+	default:
+		query = query.Order(order(forward))
+		if p.Offset > 0 {
+			query = query.Offset(p.Offset) // SQLite wants a LIMIT with any OFFSET
+		}
+	}
+	// One row more than the limit tells whether there is another page in that direction.
+	if p.Limit > 0 {
+		query = query.Limit(p.Limit + 1)
+	}
+	if err := query.Find(dest).Error; err != nil {
+		return cursors, err
+	}
+	rows := reflect.ValueOf(dest).Elem()
+	more := p.Limit > 0 && rows.Len() > p.Limit
+	if more {
+		rows.Set(rows.Slice(0, p.Limit))
+	}
+	if p.Before != nil {
+		for i, j := 0, rows.Len()-1; i < j; i, j = i+1, j-1 {
+			first, last := rows.Index(i).Interface(), rows.Index(j).Interface()
+			rows.Index(i).Set(reflect.ValueOf(last))
+			rows.Index(j).Set(reflect.ValueOf(first))
+		}
+	}
+	if rows.Len() == 0 {
+		return cursors, nil
+	}
+	cursorOf := func(row reflect.Value) Cursor {
+		cursor := Cursor{ID: uint(row.FieldByName("ID").Uint())}
+		switch at := row.FieldByName(sortBy); {
+		case sortBy == "":
+		case at.Kind() == reflect.Ptr: // like DeletedAt, set on the rows that are sorted by it
+			cursor.At = at.Elem().Interface().(time.Time)
+		default:
+			cursor.At = at.Interface().(time.Time)
+		}
+		return cursor
+	}
+	if more || p.Before != nil {
+		cursors.Next = encodeCursor(cursorOf(rows.Index(rows.Len() - 1)))
+	}
+	if (more && p.Before != nil) || p.After != nil || p.Offset > 0 {
+		cursors.Prev = encodeCursor(cursorOf(rows.Index(0)))
+	}
+	return cursors, nil
+}
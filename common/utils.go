@@ -2,7 +2,10 @@
 package common
 
 import (
+	crand "crypto/rand"
+	"encoding/base64"
 	"fmt"
+	"log"
 	"math/rand"
 	"time"
 
@@ -24,20 +27,40 @@ func RandString(n int) string {
 	return string(b)
 }
 
-// Keep this two config private, it should not expose to open source
-const NBSecretPassword = "A String Very Very Very Strong!!@##$!@#$"
+// A random url-safe string with n bytes of entropy from crypto/rand, for token ids and secrets.
+func RandToken(n int) string {
+	b := make([]byte, n)
+	crand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// A placeholder meaning "password unchanged" in update forms, it is never stored.
 const NBRandomPassword = "A String Very Very Very Niubilty!!@##$!@#4"
 
-// A Util function to generate jwt_token which can be used in the request header
-func GenToken(id uint) string {
-	jwt_token := jwt.New(jwt.GetSigningMethod("HS256"))
+// A Util function to generate jwt_token which can be used in the request header.
+// It is signed by the current signing key (see keys.go), or with GetConfig().JWT.Secret
+// in HS256 mode, and lives for GetConfig().JWT.AccessTTL. The "jti" claim is what gets
+// denylisted when the token is revoked.
+func NewToken(id uint) (string, error) {
+	now := time.Now()
 	// Set some claims
-	jwt_token.Claims = jwt.MapClaims{
+	claims := jwt.MapClaims{
 		"id":  id,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
+		"jti": RandToken(16),
+		"iat": now.Unix(),
+		"exp": now.Add(time.Duration(GetConfig().JWT.AccessTTL)).Unix(),
 	}
 	// Sign and get the complete encoded token as a string
-	token, _ := jwt_token.SignedString([]byte(NBSecretPassword))
+	return signToken(claims)
+}
+
+// NewToken for callers with nowhere to send the error, like tests mocking the header.
+// A signing error is logged and gives "".
+func GenToken(id uint) string {
+	token, err := NewToken(id)
+	if err != nil {
+		log.Printf("token: signing for user %v: %v", id, err)
+	}
 	return token
 }
 
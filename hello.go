@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-contrib/cors"
@@ -9,34 +12,32 @@ import (
 	"github.com/jinzhu/gorm"
 	"realworld-backend/articles"
 	"realworld-backend/common"
+	"realworld-backend/migrations"
 	"realworld-backend/users"
 )
 
+// Apply the pending schema migrations, `migrate status` shows which ones.
 func Migrate(db *gorm.DB) {
-	users.AutoMigrate()
-	db.AutoMigrate(&articles.ArticleModel{})
-	db.AutoMigrate(&articles.TagModel{})
-	db.AutoMigrate(&articles.FavoriteModel{})
-	db.AutoMigrate(&articles.ArticleUserModel{})
-	db.AutoMigrate(&articles.CommentModel{})
+	if _, err := migrations.Up(db, 0); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func main() {
-
-	db := common.Init()
-	Migrate(db)
-	defer db.Close()
-
+// The routes main serves, CORS included. Handlers go through common.GetDB(), so tests get
+// the same API on whatever database they set up.
+func NewRouter(cfg *common.Config) *gin.Engine {
 	r := gin.Default()
 
 	// Configure CORS
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:4100"},
+		AllowOrigins:     cfg.CORS.AllowOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		AllowCredentials: true,
 	}))
 
+	users.JWKSRegister(r.Group("/.well-known"))
+
 	v1 := r.Group("/api")
 	users.UsersRegister(v1.Group("/users"))
 	v1.Use(users.AuthMiddleware(false))
@@ -45,6 +46,7 @@ func main() {
 
 	v1.Use(users.AuthMiddleware(true))
 	users.UserRegister(v1.Group("/user"))
+	articles.UserArticlesRegister(v1.Group("/user"))
 	users.ProfileRegister(v1.Group("/profiles"))
 
 	articles.ArticlesRegister(v1.Group("/articles"))
@@ -56,6 +58,52 @@ func main() {
 			"message": "pong",
 		})
 	})
+	return r
+}
+
+func main() {
+
+	cfg, err := common.LoadConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	gin.SetMode(cfg.Mode)
+
+	db := common.Init()
+	defer db.Close()
+
+	// realworld-server migrate up|down|status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrations.Command(db, os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	Migrate(db)
+
+	// Forget revoked access tokens, refresh tokens and signing keys once they expired,
+	// and articles once they were in the trash for articles.trash_retention
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := users.PurgeExpiredTokens(); err != nil {
+				log.Println("purge tokens:", err)
+			}
+			if err := articles.PurgeTrash(); err != nil {
+				log.Println("purge trash:", err)
+			}
+		}
+	}()
+
+	// Publish scheduled articles, they are shown from their publishAt on anyway
+	go func() {
+		for range time.Tick(time.Minute) {
+			if _, err := articles.PublishScheduled(); err != nil {
+				log.Println("publish scheduled:", err)
+			}
+		}
+	}()
+
+	r := NewRouter(cfg)
 
 	// test 1 to 1
 	tx1 := db.Begin()
@@ -78,5 +126,5 @@ func main() {
 	//}).First(&userAA)
 	//fmt.Println(userAA)
 
-	r.Run() // listen and serve on 0.0.0.0:8080
+	r.Run(cfg.HTTP.Addr) // listen and serve on 0.0.0.0:8080 unless configured
 }
@@ -12,29 +12,32 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 
-	"realworld-backend/articles"
 	"realworld-backend/common"
 	"realworld-backend/users"
 )
 
+// Main's router on a fresh, migrated test database of its own.
 func setupRouter() *gin.Engine {
 	gin.SetMode(gin.TestMode)
-	db := common.InitTestDB() // You may need to implement this for test DB isolation
-	common.DB = db
-	r := gin.Default()
-	users.Routers(r)
-	articles.Routers(r)
-	return r
+	common.TestDBPath = "./gorm_integration_test.db"
+	if db := common.GetDB(); db != nil {
+		db.Close() // the previous test's
+	}
+	os.Remove(common.TestDBPath)
+	db := common.TestDBInit()
+	db.LogMode(false)
+	Migrate(db)
+	return NewRouter(common.GetConfig())
 }
 
 func TestUserRegistration(t *testing.T) {
 	router := setupRouter()
 	payload := `{"user":{"username":"testuser","email":"testuser@example.com","password":"password"}}`
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/users", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("POST", "/api/users/", bytes.NewBufferString(payload))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
-	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 201, w.Code)
 	var resp map[string]interface{}
 	json.Unmarshal(w.Body.Bytes(), &resp)
 	assert.NotNil(t, resp["user"])
@@ -45,7 +48,7 @@ func TestUserRegistrationSavedInDB(t *testing.T) {
 	router := setupRouter()
 	payload := `{"user":{"username":"dbuser","email":"dbuser@example.com","password":"password"}}`
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/users", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("POST", "/api/users/", bytes.NewBufferString(payload))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 	var user users.UserModel
@@ -57,7 +60,7 @@ func TestUserLoginSuccess(t *testing.T) {
 	router := setupRouter()
 	// Register first
 	payload := `{"user":{"username":"loginuser","email":"loginuser@example.com","password":"password"}}`
-	req, _ := http.NewRequest("POST", "/api/users", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("POST", "/api/users/", bytes.NewBufferString(payload))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(httptest.NewRecorder(), req)
 	// Login
@@ -86,7 +89,7 @@ func TestGetCurrentUserWithValidToken(t *testing.T) {
 	router := setupRouter()
 	// Register and login
 	payload := `{"user":{"username":"meuser","email":"meuser@example.com","password":"password"}}`
-	req, _ := http.NewRequest("POST", "/api/users", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("POST", "/api/users/", bytes.NewBufferString(payload))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -95,7 +98,7 @@ func TestGetCurrentUserWithValidToken(t *testing.T) {
 	token := resp["user"].(map[string]interface{})["token"].(string)
 	// Get current user
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req, _ = http.NewRequest("GET", "/api/user/", nil)
 	req.Header.Set("Authorization", "Token "+token)
 	router.ServeHTTP(w, req)
 	assert.Equal(t, 200, w.Code)
@@ -104,7 +107,7 @@ func TestGetCurrentUserWithValidToken(t *testing.T) {
 func TestGetCurrentUserWithInvalidToken(t *testing.T) {
 	router := setupRouter()
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("GET", "/api/user", nil)
+	req, _ := http.NewRequest("GET", "/api/user/", nil)
 	req.Header.Set("Authorization", "Token invalidtoken")
 	router.ServeHTTP(w, req)
 	assert.Equal(t, 401, w.Code)
@@ -113,14 +116,14 @@ func TestGetCurrentUserWithInvalidToken(t *testing.T) {
 func TestGetCurrentUserWithoutToken(t *testing.T) {
 	router := setupRouter()
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("GET", "/api/user", nil)
+	req, _ := http.NewRequest("GET", "/api/user/", nil)
 	router.ServeHTTP(w, req)
 	assert.Equal(t, 401, w.Code)
 }
 
 func registerAndLogin(router *gin.Engine, username, email, password string) string {
 	payload := fmt.Sprintf(`{"user":{"username":"%s","email":"%s","password":"%s"}}`, username, email, password)
-	req, _ := http.NewRequest("POST", "/api/users", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("POST", "/api/users/", bytes.NewBufferString(payload))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -134,7 +137,7 @@ func TestCreateArticleAuthenticated(t *testing.T) {
 	token := registerAndLogin(router, "author1", "author1@example.com", "password")
 	articlePayload := `{"article":{"title":"Test Article","description":"desc","body":"body"}}`
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(articlePayload))
+	req, _ := http.NewRequest("POST", "/api/articles/", bytes.NewBufferString(articlePayload))
 	req.Header.Set("Authorization", "Token "+token)
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
@@ -148,7 +151,7 @@ func TestCreateArticleWithoutAuth(t *testing.T) {
 	router := setupRouter()
 	articlePayload := `{"article":{"title":"No Auth","description":"desc","body":"body"}}`
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(articlePayload))
+	req, _ := http.NewRequest("POST", "/api/articles/", bytes.NewBufferString(articlePayload))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 	assert.Equal(t, 401, w.Code)
@@ -157,7 +160,7 @@ func TestCreateArticleWithoutAuth(t *testing.T) {
 func TestListArticles(t *testing.T) {
 	router := setupRouter()
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("GET", "/api/articles", nil)
+	req, _ := http.NewRequest("GET", "/api/articles/", nil)
 	router.ServeHTTP(w, req)
 	assert.Equal(t, 200, w.Code)
 	var resp map[string]interface{}
@@ -170,7 +173,7 @@ func TestGetSingleArticle(t *testing.T) {
 	token := registerAndLogin(router, "author2", "author2@example.com", "password")
 	articlePayload := `{"article":{"title":"Single Article","description":"desc","body":"body"}}`
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(articlePayload))
+	req, _ := http.NewRequest("POST", "/api/articles/", bytes.NewBufferString(articlePayload))
 	req.Header.Set("Authorization", "Token "+token)
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
@@ -189,7 +192,7 @@ func TestUpdateArticleByAuthor(t *testing.T) {
 	token := registerAndLogin(router, "author3", "author3@example.com", "password")
 	articlePayload := `{"article":{"title":"Update Me","description":"desc","body":"body"}}`
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(articlePayload))
+	req, _ := http.NewRequest("POST", "/api/articles/", bytes.NewBufferString(articlePayload))
 	req.Header.Set("Authorization", "Token "+token)
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
@@ -211,7 +214,7 @@ func TestUpdateArticleUnauthorized(t *testing.T) {
 	token := registerAndLogin(router, "author4", "author4@example.com", "password")
 	articlePayload := `{"article":{"title":"No Update","description":"desc","body":"body"}}`
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(articlePayload))
+	req, _ := http.NewRequest("POST", "/api/articles/", bytes.NewBufferString(articlePayload))
 	req.Header.Set("Authorization", "Token "+token)
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
@@ -232,7 +235,7 @@ func TestDeleteArticleByAuthor(t *testing.T) {
 	token := registerAndLogin(router, "author5", "author5@example.com", "password")
 	articlePayload := `{"article":{"title":"Delete Me","description":"desc","body":"body"}}`
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(articlePayload))
+	req, _ := http.NewRequest("POST", "/api/articles/", bytes.NewBufferString(articlePayload))
 	req.Header.Set("Authorization", "Token "+token)
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
@@ -244,7 +247,7 @@ func TestDeleteArticleByAuthor(t *testing.T) {
 	req, _ = http.NewRequest("DELETE", "/api/articles/"+slug, nil)
 	req.Header.Set("Authorization", "Token "+token)
 	router.ServeHTTP(w, req)
-	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, 200, w.Code)
 }
 
 func TestDeleteArticleUnauthorized(t *testing.T) {
@@ -252,7 +255,7 @@ func TestDeleteArticleUnauthorized(t *testing.T) {
 	token := registerAndLogin(router, "author6", "author6@example.com", "password")
 	articlePayload := `{"article":{"title":"No Delete","description":"desc","body":"body"}}`
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(articlePayload))
+	req, _ := http.NewRequest("POST", "/api/articles/", bytes.NewBufferString(articlePayload))
 	req.Header.Set("Authorization", "Token "+token)
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
@@ -271,7 +274,7 @@ func TestFavoriteUnfavoriteArticle(t *testing.T) {
 	token := registerAndLogin(router, "favuser", "favuser@example.com", "password")
 	articlePayload := `{"article":{"title":"Fav Article","description":"desc","body":"body"}}`
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(articlePayload))
+	req, _ := http.NewRequest("POST", "/api/articles/", bytes.NewBufferString(articlePayload))
 	req.Header.Set("Authorization", "Token "+token)
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
@@ -297,7 +300,7 @@ func TestArticleCommentsCRUD(t *testing.T) {
 	token := registerAndLogin(router, "commenter", "commenter@example.com", "password")
 	articlePayload := `{"article":{"title":"Comment Article","description":"desc","body":"body"}}`
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(articlePayload))
+	req, _ := http.NewRequest("POST", "/api/articles/", bytes.NewBufferString(articlePayload))
 	req.Header.Set("Authorization", "Token "+token)
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
@@ -325,12 +328,63 @@ func TestArticleCommentsCRUD(t *testing.T) {
 	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/articles/%s/comments/%d", slug, commentID), nil)
 	req.Header.Set("Authorization", "Token "+token)
 	router.ServeHTTP(w, req)
-	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestCommentDeleteScopedToArticle(t *testing.T) {
+	router := setupRouter()
+	token := registerAndLogin(router, "scoped", "scoped@example.com", "password")
+	createArticle := func(title string) string {
+		payload := fmt.Sprintf(`{"article":{"title":"%s","description":"desc","body":"body"}}`, title)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/articles/", bytes.NewBufferString(payload))
+		req.Header.Set("Authorization", "Token "+token)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp["article"].(map[string]interface{})["slug"].(string)
+	}
+	slug := createArticle("Scoped Article")
+	otherSlug := createArticle("Other Scoped Article")
+	// Comment on the first article
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/articles/"+slug+"/comments", bytes.NewBufferString(`{"comment":{"body":"Stay here"}}`))
+	req.Header.Set("Authorization", "Token "+token)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 201, w.Code)
+	var commentResp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &commentResp)
+	commentID := int(commentResp["comment"].(map[string]interface{})["id"].(float64))
+	// Delete it through the other article's slug
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/articles/%s/comments/%d", otherSlug, commentID), nil)
+	req.Header.Set("Authorization", "Token "+token)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+	// Delete a comment which doesn't exist
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/articles/%s/comments/%d", slug, commentID+1000), nil)
+	req.Header.Set("Authorization", "Token "+token)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+	// The comment is still there
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/articles/"+slug+"/comments", nil)
+	router.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "Stay here")
+	// Its own slug deletes it
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/articles/%s/comments/%d", slug, commentID), nil)
+	req.Header.Set("Authorization", "Token "+token)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
 }
 
 // Clean up test DB if needed
 func TestMain(m *testing.M) {
 	code := m.Run()
-	os.Remove("test.db")
+	os.Remove(common.TestDBPath)
 	os.Exit(code)
-}
\ No newline at end of file
+}
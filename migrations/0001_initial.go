@@ -0,0 +1,99 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// The schema users.AutoMigrate and articles.AutoMigrate used to build, frozen as it was.
+
+type userModel0001 struct {
+	ID           uint    `gorm:"primary_key"`
+	Username     string  `gorm:"column:username"`
+	Email        string  `gorm:"column:email;unique_index"`
+	Bio          string  `gorm:"column:bio;size:1024"`
+	Image        *string `gorm:"column:image"`
+	PasswordHash string  `gorm:"column:password;not null"`
+}
+
+func (userModel0001) TableName() string { return "user_models" }
+
+type followModel0001 struct {
+	gorm.Model
+	FollowingID  uint
+	FollowedByID uint
+}
+
+func (followModel0001) TableName() string { return "follow_models" }
+
+type articleModel0001 struct {
+	gorm.Model
+	Slug        string `gorm:"unique_index"`
+	Title       string
+	Description string `gorm:"size:2048"`
+	Body        string `gorm:"size:2048"`
+	AuthorID    uint
+}
+
+func (articleModel0001) TableName() string { return "article_models" }
+
+type tagModel0001 struct {
+	gorm.Model
+	Tag string `gorm:"unique_index"`
+}
+
+func (tagModel0001) TableName() string { return "tag_models" }
+
+type articleTag0001 struct {
+	ArticleModelID uint `gorm:"primary_key;auto_increment:false"`
+	TagModelID     uint `gorm:"primary_key;auto_increment:false"`
+}
+
+func (articleTag0001) TableName() string { return "article_tags" }
+
+type favoriteModel0001 struct {
+	gorm.Model
+	FavoriteID   uint
+	FavoriteByID uint
+}
+
+func (favoriteModel0001) TableName() string { return "favorite_models" }
+
+type articleUserModel0001 struct {
+	gorm.Model
+	UserModelID uint
+}
+
+func (articleUserModel0001) TableName() string { return "article_user_models" }
+
+type commentModel0001 struct {
+	gorm.Model
+	ArticleID uint
+	AuthorID  uint
+	Body      string `gorm:"size:2048"`
+}
+
+func (commentModel0001) TableName() string { return "comment_models" }
+
+var models0001 = []interface{}{
+	&userModel0001{},
+	&followModel0001{},
+	&articleUserModel0001{},
+	&articleModel0001{},
+	&tagModel0001{},
+	&articleTag0001{},
+	&favoriteModel0001{},
+	&commentModel0001{},
+}
+
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "initial",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, models0001...)
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, models0001...)
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Refresh tokens and the access token denylist.
+
+type refreshTokenModel0002 struct {
+	gorm.Model
+	UserModelID uint
+	TokenHash   string `gorm:"unique_index"`
+	Family      string `gorm:"index"`
+	ExpiresAt   time.Time
+	RevokedAt   *time.Time
+}
+
+func (refreshTokenModel0002) TableName() string { return "refresh_token_models" }
+
+type revokedTokenModel0002 struct {
+	ID        uint   `gorm:"primary_key"`
+	JTI       string `gorm:"column:jti;unique_index"`
+	ExpiresAt time.Time
+}
+
+func (revokedTokenModel0002) TableName() string { return "revoked_token_models" }
+
+var models0002 = []interface{}{
+	&refreshTokenModel0002{},
+	&revokedTokenModel0002{},
+}
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "auth_tokens",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, models0002...)
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, models0002...)
+		},
+	})
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The key pairs access tokens are signed with, see common.SigningKeyModel.
+
+type signingKeyModel0003 struct {
+	ID         uint   `gorm:"primary_key"`
+	KID        string `gorm:"column:kid;unique_index"`
+	Algorithm  string
+	PrivateKey string `gorm:"size:4096"`
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+}
+
+func (signingKeyModel0003) TableName() string { return "signing_key_models" }
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "signing_keys",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, &signingKeyModel0003{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, &signingKeyModel0003{})
+		},
+	})
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The slugs articles had before their title changed.
+
+type articleSlugModel0004 struct {
+	ID        uint   `gorm:"primary_key"`
+	Slug      string `gorm:"unique_index"`
+	ArticleID uint   `gorm:"index"`
+	CreatedAt time.Time
+}
+
+func (articleSlugModel0004) TableName() string { return "article_slug_models" }
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "article_slugs",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, &articleSlugModel0004{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, &articleSlugModel0004{})
+		},
+	})
+}
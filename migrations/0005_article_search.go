@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// The full-text index of articles: an FTS5 table on SQLite, FTS4 when the driver has no
+// FTS5, and a weighted tsvector on Postgres. MySQL has no search. Existing articles are
+// indexed right away.
+
+func createArticleSearch0005(tx *gorm.DB) error {
+	if tx.HasTable("article_search") {
+		return nil
+	}
+	var statements []string
+	switch tx.Dialect().GetName() {
+	case "sqlite3":
+		module := "fts5(title, description, body, tags, comments, tokenize = 'porter unicode61')"
+		var fts5 int
+		tx.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Row().Scan(&fts5)
+		if fts5 == 0 {
+			module = "fts4(title, description, body, tags, comments, tokenize=porter)"
+		}
+		statements = []string{
+			"CREATE VIRTUAL TABLE article_search USING " + module,
+			`INSERT INTO article_search (rowid, title, description, body, tags, comments)
+SELECT a.id, a.title, a.description, a.body,
+	COALESCE((SELECT group_concat(t.tag, ' ') FROM article_tags x JOIN tag_models t ON t.id = x.tag_model_id WHERE x.article_model_id = a.id), ''),
+	COALESCE((SELECT group_concat(c.body, ' ') FROM comment_models c WHERE c.article_id = a.id AND c.deleted_at IS NULL), '')
+FROM article_models a WHERE a.deleted_at IS NULL`,
+		}
+	case "postgres":
+		statements = []string{
+			"CREATE TABLE article_search (article_id integer PRIMARY KEY, document tsvector NOT NULL)",
+			"CREATE INDEX idx_article_search_document ON article_search USING GIN (document)",
+			`INSERT INTO article_search (article_id, document)
+SELECT a.id,
+	setweight(to_tsvector('english', a.title), 'A') ||
+	setweight(to_tsvector('english', COALESCE((SELECT string_agg(t.tag, ' ') FROM article_tags x JOIN tag_models t ON t.id = x.tag_model_id WHERE x.article_model_id = a.id), '')), 'B') ||
+	setweight(to_tsvector('english', a.description), 'C') ||
+	setweight(to_tsvector('english', a.body || ' ' || COALESCE((SELECT string_agg(c.body, ' ') FROM comment_models c WHERE c.article_id = a.id AND c.deleted_at IS NULL), '')), 'D')
+FROM article_models a WHERE a.deleted_at IS NULL`,
+		}
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "article_search",
+		Up:      createArticleSearch0005,
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("DROP TABLE IF EXISTS article_search").Error
+		},
+	})
+}
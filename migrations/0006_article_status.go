@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Draft, scheduled and published articles. Existing articles are published.
+
+type articleModel0006 struct {
+	ID        uint   `gorm:"primary_key"`
+	Status    string `gorm:"size:16;index;default:'published'"`
+	PublishAt *time.Time
+}
+
+func (articleModel0006) TableName() string { return "article_models" }
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "article_status",
+		Up: func(tx *gorm.DB) error {
+			if err := addColumns(tx, &articleModel0006{}); err != nil {
+				return err
+			}
+			return tx.Exec("UPDATE article_models SET status = 'published' WHERE status IS NULL").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			model := tx.Model(&articleModel0006{})
+			if err := model.RemoveIndex("idx_article_models_status").Error; err != nil {
+				return err
+			}
+			if err := model.DropColumn("publish_at").Error; err != nil {
+				return err
+			}
+			return model.DropColumn("status").Error
+		},
+	})
+}
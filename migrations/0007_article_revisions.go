@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Every save of an article's title, description or body. Existing articles start with
+// their current text as revision 1.
+
+type articleRevisionModel0007 struct {
+	ID          uint `gorm:"primary_key"`
+	ArticleID   uint `gorm:"unique_index:idx_article_revision_number"`
+	Number      uint `gorm:"unique_index:idx_article_revision_number"`
+	Title       string
+	Description string `gorm:"size:2048"`
+	Body        string `gorm:"size:2048"`
+	EditorID    uint
+	Changed     string
+	CreatedAt   time.Time
+}
+
+func (articleRevisionModel0007) TableName() string { return "article_revision_models" }
+
+func init() {
+	register(Migration{
+		Version: 7,
+		Name:    "article_revisions",
+		Up: func(tx *gorm.DB) error {
+			if tx.HasTable(&articleRevisionModel0007{}) {
+				return nil
+			}
+			if err := createTables(tx, &articleRevisionModel0007{}); err != nil {
+				return err
+			}
+			return tx.Exec(`INSERT INTO article_revision_models (article_id, number, title, description, body, editor_id, changed, created_at)
+SELECT id, 1, title, description, body, author_id, 'title,description,body', updated_at FROM article_models`).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, &articleRevisionModel0007{})
+		},
+	})
+}
@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Replies to comments. Existing comments are at the top of their article's thread.
+
+type commentModel0008 struct {
+	ID       uint  `gorm:"primary_key"`
+	ParentID *uint `gorm:"index"`
+	Depth    uint  `gorm:"default:0"`
+	Removed  bool  `gorm:"default:false"`
+}
+
+func (commentModel0008) TableName() string { return "comment_models" }
+
+func init() {
+	register(Migration{
+		Version: 8,
+		Name:    "comment_threads",
+		Up: func(tx *gorm.DB) error {
+			return addColumns(tx, &commentModel0008{})
+		},
+		Down: func(tx *gorm.DB) error {
+			model := tx.Model(&commentModel0008{})
+			if err := model.RemoveIndex("idx_comment_models_parent_id").Error; err != nil {
+				return err
+			}
+			for _, column := range []string{"removed", "depth", "parent_id"} {
+				if err := model.DropColumn(column).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
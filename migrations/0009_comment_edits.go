@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Comment editing: when a comment was last edited, and the bodies its edits replaced.
+
+type commentModel0009 struct {
+	ID       uint `gorm:"primary_key"`
+	EditedAt *time.Time
+}
+
+func (commentModel0009) TableName() string { return "comment_models" }
+
+type commentEditModel0009 struct {
+	ID        uint   `gorm:"primary_key"`
+	CommentID uint   `gorm:"index"`
+	Body      string `gorm:"size:2048"`
+	EditorID  uint
+	CreatedAt time.Time
+}
+
+func (commentEditModel0009) TableName() string { return "comment_edit_models" }
+
+func init() {
+	register(Migration{
+		Version: 9,
+		Name:    "comment_edits",
+		Up: func(tx *gorm.DB) error {
+			if err := addColumns(tx, &commentModel0009{}); err != nil {
+				return err
+			}
+			return createTables(tx, &commentEditModel0009{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropTables(tx, &commentEditModel0009{}); err != nil {
+				return err
+			}
+			return tx.Model(&commentModel0009{}).DropColumn("edited_at").Error
+		},
+	})
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Reactions to articles, next to favorites which stay as they are.
+
+type reactionModel0010 struct {
+	ID        uint   `gorm:"primary_key"`
+	ArticleID uint   `gorm:"unique_index:idx_article_reaction"`
+	UserID    uint   `gorm:"unique_index:idx_article_reaction"`
+	Reaction  string `gorm:"size:32;unique_index:idx_article_reaction"`
+	CreatedAt time.Time
+}
+
+func (reactionModel0010) TableName() string { return "reaction_models" }
+
+func init() {
+	register(Migration{
+		Version: 10,
+		Name:    "article_reactions",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, &reactionModel0010{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, &reactionModel0010{})
+		},
+	})
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Private bookmarks of articles, optionally in folders.
+
+type bookmarkModel0011 struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"unique_index:idx_user_bookmark"`
+	ArticleID uint   `gorm:"unique_index:idx_user_bookmark"`
+	Folder    string `gorm:"size:64"`
+	CreatedAt time.Time
+}
+
+func (bookmarkModel0011) TableName() string { return "bookmark_models" }
+
+func init() {
+	register(Migration{
+		Version: 11,
+		Name:    "bookmarks",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, &bookmarkModel0011{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, &bookmarkModel0011{})
+		},
+	})
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Users blocking and muting other users.
+
+type blockModel0012 struct {
+	ID        uint `gorm:"primary_key"`
+	BlockerID uint `gorm:"unique_index:idx_block"`
+	BlockedID uint `gorm:"unique_index:idx_block"`
+	CreatedAt time.Time
+}
+
+func (blockModel0012) TableName() string { return "block_models" }
+
+type muteModel0012 struct {
+	ID        uint `gorm:"primary_key"`
+	MuterID   uint `gorm:"unique_index:idx_mute"`
+	MutedID   uint `gorm:"unique_index:idx_mute"`
+	CreatedAt time.Time
+}
+
+func (muteModel0012) TableName() string { return "mute_models" }
+
+var models0012 = []interface{}{
+	&blockModel0012{},
+	&muteModel0012{},
+}
+
+func init() {
+	register(Migration{
+		Version: 12,
+		Name:    "blocks_and_mutes",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, models0012...)
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, models0012...)
+		},
+	})
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Private accounts, whose follows are requests waiting for approval. Existing users stay
+// public.
+
+type userModel0013 struct {
+	ID      uint `gorm:"primary_key"`
+	Private bool `gorm:"column:private;default:false"`
+}
+
+func (userModel0013) TableName() string { return "user_models" }
+
+type followRequestModel0013 struct {
+	ID          uint `gorm:"primary_key"`
+	RequesterID uint `gorm:"unique_index:idx_follow_request"`
+	TargetID    uint `gorm:"unique_index:idx_follow_request"`
+	CreatedAt   time.Time
+}
+
+func (followRequestModel0013) TableName() string { return "follow_request_models" }
+
+func init() {
+	register(Migration{
+		Version: 13,
+		Name:    "private_accounts",
+		Up: func(tx *gorm.DB) error {
+			if err := addColumns(tx, &userModel0013{}); err != nil {
+				return err
+			}
+			return createTables(tx, &followRequestModel0013{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropTables(tx, &followRequestModel0013{}); err != nil {
+				return err
+			}
+			return tx.Model(&userModel0013{}).DropColumn("private").Error
+		},
+	})
+}
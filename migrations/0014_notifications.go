@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// In-app notifications of follows, favorites and comments, and who is behind each.
+
+type notificationModel0014 struct {
+	ID           uint   `gorm:"primary_key"`
+	UserID       uint   `gorm:"index"`
+	Kind         string `gorm:"size:16"`
+	ArticleID    uint
+	ArticleSlug  string
+	ArticleTitle string
+	ActorID      uint
+	ActorsCount  int
+	ReadAt       *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (notificationModel0014) TableName() string { return "notification_models" }
+
+type notificationActorModel0014 struct {
+	ID             uint `gorm:"primary_key"`
+	NotificationID uint `gorm:"unique_index:idx_notification_actor"`
+	ActorID        uint `gorm:"unique_index:idx_notification_actor"`
+	CreatedAt      time.Time
+}
+
+func (notificationActorModel0014) TableName() string { return "notification_actor_models" }
+
+var models0014 = []interface{}{
+	&notificationModel0014{},
+	&notificationActorModel0014{},
+}
+
+func init() {
+	register(Migration{
+		Version: 14,
+		Name:    "notifications",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, models0014...)
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, models0014...)
+		},
+	})
+}
@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+)
+
+// Usernames and emails unique whatever their case, as they are looked up. It fails on
+// existing users differing only in case, one of them has to be renamed first.
+
+type userModel0015 struct {
+	ID uint `gorm:"primary_key"`
+}
+
+func (userModel0015) TableName() string { return "user_models" }
+
+func init() {
+	register(Migration{
+		Version: 15,
+		Name:    "case_insensitive_users",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"username", "email"} {
+				if err := common.CreateLowerUniqueIndex(tx, "user_models", column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"username", "email"} {
+				if err := tx.Model(&userModel0015{}).RemoveIndex("uix_user_models_lower_" + column).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,72 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+)
+
+var errUsage = errors.New("usage: migrate up [version] | down [steps] | status")
+
+// The `migrate` subcommand of the server binary, args are what follows "migrate":
+//
+//	realworld-server migrate up         apply every pending migration
+//	realworld-server migrate up 3       apply pending migrations up to version 3
+//	realworld-server migrate down       revert the last applied migration
+//	realworld-server migrate down 2     revert the last two
+//	realworld-server migrate status     list migrations and whether they are applied
+func Command(db *gorm.DB, args []string, out io.Writer) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errUsage
+	}
+	var number uint64
+	if len(args) == 2 {
+		var err error
+		if number, err = strconv.ParseUint(args[1], 10, 32); err != nil {
+			return errUsage
+		}
+	}
+	switch args[0] {
+	case "up":
+		ran, err := Up(db, uint(number))
+		for _, migration := range ran {
+			fmt.Fprintf(out, "applied  %04d_%v\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(ran) == 0 {
+			fmt.Fprintln(out, "nothing to apply")
+		}
+		return err
+	case "down":
+		if number == 0 {
+			number = 1
+		}
+		ran, err := Down(db, int(number))
+		for _, migration := range ran {
+			fmt.Fprintf(out, "reverted %04d_%v\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(ran) == 0 {
+			fmt.Fprintln(out, "nothing to revert")
+		}
+		return err
+	case "status":
+		if len(args) != 1 {
+			return errUsage
+		}
+		statuses, err := List(db)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.UTC().Format("2006-01-02T15:04:05Z")
+			}
+			fmt.Fprintf(out, "%04d_%-30v %v\n", status.Version, status.Name, state)
+		}
+		return nil
+	}
+	return errUsage
+}
@@ -0,0 +1,13 @@
+/*
+The migrations module containing the versioned schema changes and the `migrate` subcommand.
+
+migrations.go: the registry, schema_migrations bookkeeping and up/down/status
+
+command.go: the `migrate up|down|status` subcommand
+
+NNNN_name.go: one migration each, registered in init()
+
+The models' AutoMigrate functions are only used to build throwaway test databases,
+TestMigrationsMatchModels makes sure they agree with the migrations.
+*/
+package migrations
@@ -0,0 +1,187 @@
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// One schema change. Up applies it and Down reverts it, both run inside a transaction
+// together with the schema_migrations bookkeeping.
+//
+// Migrations are frozen once released: they describe tables with their own copies of
+// the models, so later changes to users.UserModel etc. need a new migration.
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// A row of schema_migrations, one per applied migration.
+type SchemaMigration struct {
+	Version   uint `gorm:"primary_key;auto_increment:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// What `migrate status` prints for each migration.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var registry []Migration
+
+// Add a migration, each file in this package registers its own in init().
+// 	func init() { register(Migration{Version: 2, Name: "add_x", Up: ..., Down: ...}) }
+func register(migration Migration) {
+	for _, m := range registry {
+		if m.Version == migration.Version {
+			panic(fmt.Sprintf("migrations: version %v registered twice", migration.Version))
+		}
+	}
+	registry = append(registry, migration)
+	sort.Slice(registry, func(i, j int) bool { return registry[i].Version < registry[j].Version })
+}
+
+// All known migrations, oldest first.
+func All() []Migration {
+	return append([]Migration(nil), registry...)
+}
+
+func applied(db *gorm.DB) (map[uint]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	done := make(map[uint]SchemaMigration)
+	for _, row := range rows {
+		done[row.Version] = row
+	}
+	return done, nil
+}
+
+// Apply every pending migration up to and including target, 0 means all of them.
+// It returns the migrations it applied.
+func Up(db *gorm.DB, target uint) ([]Migration, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	var ran []Migration
+	for _, migration := range registry {
+		if target != 0 && migration.Version > target {
+			break
+		}
+		if _, ok := done[migration.Version]; ok {
+			continue
+		}
+		err := run(db, migration, migration.Up, func(tx *gorm.DB) error {
+			return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return ran, err
+		}
+		ran = append(ran, migration)
+	}
+	return ran, nil
+}
+
+// Revert the last `steps` applied migrations, newest first.
+// It returns the migrations it reverted.
+func Down(db *gorm.DB, steps int) ([]Migration, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	var ran []Migration
+	for i := len(registry) - 1; i >= 0 && len(ran) < steps; i-- {
+		migration := registry[i]
+		if _, ok := done[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == nil {
+			return ran, fmt.Errorf("migrations: %v_%v can not be reverted", migration.Version, migration.Name)
+		}
+		err := run(db, migration, migration.Down, func(tx *gorm.DB) error {
+			return tx.Delete(&SchemaMigration{Version: migration.Version}).Error
+		})
+		if err != nil {
+			return ran, err
+		}
+		ran = append(ran, migration)
+	}
+	return ran, nil
+}
+
+// Every known migration with whether it has been applied.
+func List(db *gorm.DB) ([]Status, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	for _, migration := range registry {
+		row, ok := done[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: row.AppliedAt})
+	}
+	return statuses, nil
+}
+
+func run(db *gorm.DB, migration Migration, change, record func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if err := change(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrations: %v_%v: %v", migration.Version, migration.Name, err)
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrations: %v_%v: %v", migration.Version, migration.Name, err)
+	}
+	return tx.Commit().Error
+}
+
+// Create the tables of the given snapshot models which don't exist yet. The initial
+// migration uses it so databases created by the old AutoMigrate are adopted as they are.
+func createTables(tx *gorm.DB, models ...interface{}) error {
+	for _, model := range models {
+		if tx.HasTable(model) {
+			continue
+		}
+		if err := tx.CreateTable(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dropTables(tx *gorm.DB, models ...interface{}) error {
+	for _, model := range models {
+		if err := tx.DropTableIfExists(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add the columns and indexes of the given snapshot models which their existing tables
+// lack. A snapshot only needs the primary key and the new fields.
+func addColumns(tx *gorm.DB, models ...interface{}) error {
+	for _, model := range models {
+		if err := tx.AutoMigrate(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
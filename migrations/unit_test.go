@@ -0,0 +1,170 @@
+package migrations
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+
+	"realworld-backend/articles"
+	"realworld-backend/common"
+	"realworld-backend/users"
+)
+
+var test_db *gorm.DB
+
+// The columns and indexes of every table, as SQLite describes them.
+func describeSchema(db *gorm.DB) map[string][]string {
+	schema := make(map[string][]string)
+	var tables []string
+	db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT IN ('sqlite_sequence', 'schema_migrations')").Pluck("name", &tables)
+	for _, table := range tables {
+		rows, _ := db.Raw(fmt.Sprintf("PRAGMA table_info(%q)", table)).Rows()
+		for rows.Next() {
+			var cid, notNull, pk int
+			var name, kind string
+			var value *string
+			rows.Scan(&cid, &name, &kind, &notNull, &value, &pk)
+			schema[table] = append(schema[table], fmt.Sprintf("column %v %v notnull=%v pk=%v", name, kind, notNull, pk))
+		}
+		rows.Close()
+		// gorm creates the indexes of a model in map order.
+		var indexes []string
+		rows, _ = db.Raw(fmt.Sprintf("PRAGMA index_list(%q)", table)).Rows()
+		for rows.Next() {
+			var seq, unique, partial int
+			var name, origin string
+			rows.Scan(&seq, &name, &unique, &origin, &partial)
+			indexes = append(indexes, fmt.Sprintf("index %v unique=%v", name, unique))
+		}
+		rows.Close()
+		sort.Strings(indexes)
+		schema[table] = append(schema[table], indexes...)
+	}
+	return schema
+}
+
+func resetDB() {
+	common.TestDBFree(test_db)
+	test_db = common.TestDBInit()
+}
+
+func TestMigrationsUpDown(t *testing.T) {
+	asserts := assert.New(t)
+	resetDB()
+
+	ran, err := Up(test_db, 0)
+	asserts.NoError(err, "migrations should apply on an empty database")
+	asserts.Len(ran, len(All()), "every migration should be applied")
+	asserts.True(test_db.HasTable("user_models"), "user_models should be created")
+	asserts.True(test_db.HasTable("article_tags"), "article_tags should be created")
+
+	ran, err = Up(test_db, 0)
+	asserts.NoError(err)
+	asserts.Len(ran, 0, "applied migrations should not run twice")
+
+	statuses, err := List(test_db)
+	asserts.NoError(err)
+	for _, status := range statuses {
+		asserts.True(status.Applied, "migration should be applied")
+	}
+
+	ran, err = Down(test_db, len(All()))
+	asserts.NoError(err, "migrations should revert")
+	asserts.Len(ran, len(All()))
+	asserts.False(test_db.HasTable("user_models"), "user_models should be dropped")
+	asserts.False(test_db.HasTable("comment_models"), "comment_models should be dropped")
+
+	statuses, err = List(test_db)
+	asserts.NoError(err)
+	for _, status := range statuses {
+		asserts.False(status.Applied, "migration should be pending after down")
+	}
+}
+
+func TestMigrationsAdoptAutoMigratedDatabase(t *testing.T) {
+	asserts := assert.New(t)
+	resetDB()
+	users.AutoMigrate()
+	articles.AutoMigrate()
+	test_db.Create(&users.UserModel{Username: "user1", Email: "user1@linkedin.com", PasswordHash: "hashed"})
+
+	_, err := Up(test_db, 0)
+	asserts.NoError(err, "migrations should adopt a database built by AutoMigrate")
+	var count int
+	test_db.Model(&users.UserModel{}).Count(&count)
+	asserts.Equal(1, count, "existing rows should be kept")
+}
+
+func TestMigrationsMatchModels(t *testing.T) {
+	asserts := assert.New(t)
+	if test_db.Dialect().GetName() != "sqlite3" {
+		t.Skip("schema comparison reads SQLite pragmas")
+	}
+	resetDB()
+	users.AutoMigrate()
+	articles.AutoMigrate()
+	expected := describeSchema(test_db)
+
+	resetDB()
+	_, err := Up(test_db, 0)
+	asserts.NoError(err)
+	asserts.Equal(expected, describeSchema(test_db), "migrations should build the schema the models describe")
+}
+
+func TestArticleStatusMigration(t *testing.T) {
+	asserts := assert.New(t)
+	resetDB()
+	_, err := Up(test_db, 5)
+	asserts.NoError(err)
+	asserts.NoError(test_db.Exec("INSERT INTO article_models (slug, title) VALUES ('old', 'Old')").Error)
+
+	_, err = Up(test_db, 6)
+	asserts.NoError(err)
+	var status string
+	test_db.Table("article_models").Where("slug = 'old'").Select("status").Row().Scan(&status)
+	asserts.Equal("published", status, "existing articles should be published")
+
+	_, err = Down(test_db, 1)
+	asserts.NoError(err, "status columns should be dropped")
+	asserts.False(test_db.Dialect().HasColumn("article_models", "status"))
+}
+
+func TestCommand(t *testing.T) {
+	asserts := assert.New(t)
+	resetDB()
+	var out bytes.Buffer
+
+	asserts.NoError(Command(test_db, []string{"status"}, &out))
+	asserts.Contains(out.String(), "0001_initial")
+	asserts.Contains(out.String(), "pending")
+
+	out.Reset()
+	asserts.NoError(Command(test_db, []string{"up", "1"}, &out))
+	asserts.Equal("applied  0001_initial\n", out.String())
+
+	out.Reset()
+	asserts.NoError(Command(test_db, []string{"status"}, &out))
+	asserts.Contains(out.String(), "applied 20")
+
+	out.Reset()
+	asserts.NoError(Command(test_db, []string{"down"}, &out))
+	asserts.Contains(out.String(), "reverted 0001_initial")
+
+	asserts.Equal(errUsage, Command(test_db, []string{}, &out), "missing action should print usage")
+	asserts.Equal(errUsage, Command(test_db, []string{"sideways"}, &out), "unknown action should print usage")
+	asserts.Equal(errUsage, Command(test_db, []string{"down", "x"}, &out), "bad number should print usage")
+}
+
+//Each package gets its own database file, so `go test ./...` can run packages in parallel.
+func TestMain(m *testing.M) {
+	common.TestDBPath = "./../gorm_migrations_test.db"
+	test_db = common.TestDBInit()
+	exitVal := m.Run()
+	common.TestDBFree(test_db)
+	os.Exit(exitVal)
+}
@@ -8,5 +8,7 @@ routers.go: router binding and core logic
 serializers.go: definition the schema of return data
 
 validators.go: definition the validator of form data
+
+notifications.go: the notifications of follows, favorites and comments, gathered per unread notification
 */
 package users
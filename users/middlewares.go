@@ -1,6 +1,7 @@
 package users
 
 import (
+	"errors"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/dgrijalva/jwt-go/request"
 	"realworld-backend/common"
@@ -21,8 +22,8 @@ func stripBearerPrefixFromTokenString(tok string) (string, error) {
 // Extract  token from Authorization header
 // Uses PostExtractionFilter to strip "TOKEN " prefix from header
 var AuthorizationHeaderExtractor = &request.PostExtractionFilter{
-	request.HeaderExtractor{"Authorization"},
-	stripBearerPrefixFromTokenString,
+	Extractor: request.HeaderExtractor{"Authorization"},
+	Filter:    stripBearerPrefixFromTokenString,
 }
 
 // Extractor for OAuth2 access tokens.  Looks in 'Authorization'
@@ -48,10 +49,7 @@ func UpdateContextUserModel(c *gin.Context, my_user_id uint) {
 func AuthMiddleware(auto401 bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		UpdateContextUserModel(c, 0)
-		token, err := request.ParseFromRequest(c.Request, MyAuth2Extractor, func(token *jwt.Token) (interface{}, error) {
-			b := ([]byte(common.NBSecretPassword))
-			return b, nil
-		})
+		token, err := request.ParseFromRequest(c.Request, MyAuth2Extractor, common.VerificationKey)
 		if err != nil {
 			if auto401 {
 				c.AbortWithError(http.StatusUnauthorized, err)
@@ -59,9 +57,18 @@ func AuthMiddleware(auto401 bool) gin.HandlerFunc {
 			return
 		}
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+			// Tokens without a jti can't be revoked, so they are not accepted either.
+			jti, _ := claims["jti"].(string)
+			if jti == "" || IsAccessTokenRevoked(jti) {
+				if auto401 {
+					c.AbortWithError(http.StatusUnauthorized, errors.New("token revoked"))
+				}
+				return
+			}
 			my_user_id := uint(claims["id"].(float64))
 			//fmt.Println(my_user_id,claims["id"])
 			UpdateContextUserModel(c, my_user_id)
+			c.Set("my_token_claims", claims)
 		}
 	}
 }
@@ -1,10 +1,13 @@
 package users
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"github.com/jinzhu/gorm"
 	"realworld-backend/common"
 	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
 // Models should only be concerned with database schema, more strict checking should be put in validator.
@@ -19,6 +22,8 @@ type UserModel struct {
 	Bio          string  `gorm:"column:bio;size:1024"`
 	Image        *string `gorm:"column:image"`
 	PasswordHash string  `gorm:"column:password;not null"`
+	// Follows become requests the user approves, and only followers see their articles.
+	Private bool `gorm:"column:private;default:false"`
 }
 
 // A hack way to save ManyToMany relationship,
@@ -39,12 +44,75 @@ type FollowModel struct {
 	FollowedByID uint
 }
 
+// Requester asked to follow the private user Target, who hasn't answered yet.
+type FollowRequestModel struct {
+	ID          uint `gorm:"primary_key"`
+	Requester   UserModel
+	RequesterID uint `gorm:"unique_index:idx_follow_request"`
+	TargetID    uint `gorm:"unique_index:idx_follow_request"`
+	CreatedAt   time.Time
+}
+
+// Blocker blocked Blocked: Blocked can't follow Blocker or comment on, favorite or react to
+// their articles, and Blocker doesn't see anything Blocked writes.
+type BlockModel struct {
+	ID        uint `gorm:"primary_key"`
+	BlockerID uint `gorm:"unique_index:idx_block"`
+	BlockedID uint `gorm:"unique_index:idx_block"`
+	CreatedAt time.Time
+}
+
+// Muter muted Muted: Muter doesn't see anything Muted writes, and that's all, Muted doesn't
+// know.
+type MuteModel struct {
+	ID        uint `gorm:"primary_key"`
+	MuterID   uint `gorm:"unique_index:idx_mute"`
+	MutedID   uint `gorm:"unique_index:idx_mute"`
+	CreatedAt time.Time
+}
+
+// A refresh token handed out at login. Only its sha256 is stored, the token itself is
+// only ever seen by the client.
+//
+// Every refresh revokes the token and issues a new one in the same family, so when a
+// revoked token shows up again it has been stolen and the whole family is revoked.
+type RefreshTokenModel struct {
+	gorm.Model
+	UserModel   UserModel
+	UserModelID uint
+	TokenHash   string `gorm:"unique_index"`
+	Family      string `gorm:"index"`
+	ExpiresAt   time.Time
+	RevokedAt   *time.Time
+}
+
+// The denylist of access tokens revoked before they expire, by their "jti" claim.
+// Rows are useless once ExpiresAt has passed, PurgeExpiredTokens removes them.
+type RevokedTokenModel struct {
+	ID        uint   `gorm:"primary_key"`
+	JTI       string `gorm:"column:jti;unique_index"`
+	ExpiresAt time.Time
+}
+
+var errInvalidRefreshToken = errors.New("Invalid or expired refresh token")
+
 // Migrate the schema of database if needed
 func AutoMigrate() {
 	db := common.GetDB()
 
 	db.AutoMigrate(&UserModel{})
+	// Lookups ignore case, so does uniqueness
+	common.CreateLowerUniqueIndex(db, "user_models", "username")
+	common.CreateLowerUniqueIndex(db, "user_models", "email")
 	db.AutoMigrate(&FollowModel{})
+	db.AutoMigrate(&RefreshTokenModel{})
+	db.AutoMigrate(&RevokedTokenModel{})
+	db.AutoMigrate(&FollowRequestModel{})
+	db.AutoMigrate(&BlockModel{})
+	db.AutoMigrate(&NotificationModel{})
+	db.AutoMigrate(&NotificationActorModel{})
+	db.AutoMigrate(&MuteModel{})
+	db.AutoMigrate(&common.SigningKeyModel{})
 }
 
 // What's bcrypt? https://en.wikipedia.org/wiki/Bcrypt
@@ -72,10 +140,11 @@ func (u *UserModel) checkPassword(password string) error {
 
 // You could input the conditions and it will return an UserModel in database with error info.
 // 	userModel, err := FindOneUser(&UserModel{Username: "username0"})
-func FindOneUser(condition interface{}) (UserModel, error) {
+// 	userModel, err := FindOneUser(common.LowerEqual("email"), "Jake@Jake.jake")
+func FindOneUser(condition interface{}, args ...interface{}) (UserModel, error) {
 	db := common.GetDB()
 	var model UserModel
-	err := db.Where(condition).First(&model).Error
+	err := db.Where(condition, args...).First(&model).Error
 	return model, err
 }
 
@@ -84,7 +153,7 @@ func FindOneUser(condition interface{}) (UserModel, error) {
 func SaveOne(data interface{}) error {
 	db := common.GetDB()
 	err := db.Save(data).Error
-	return err
+	return common.NormalizeDBError(err)
 }
 
 // You could update properties of an UserModel to database returning with error info.
@@ -92,7 +161,7 @@ func SaveOne(data interface{}) error {
 func (model *UserModel) Update(data interface{}) error {
 	db := common.GetDB()
 	err := db.Model(model).Update(data).Error
-	return err
+	return common.NormalizeDBError(err)
 }
 
 // You could add a following relationship as userModel1 following userModel2
@@ -104,7 +173,7 @@ func (u UserModel) following(v UserModel) error {
 		FollowingID:  v.ID,
 		FollowedByID: u.ID,
 	}).Error
-	return err
+	return common.NormalizeDBError(err)
 }
 
 // You could check whether  userModel1 following userModel2
@@ -119,6 +188,22 @@ func (u UserModel) isFollowing(v UserModel) bool {
 	return follow.ID != 0
 }
 
+// Which of the users with the given ids u follows, in one query.
+// 	following := myUserModel.FollowingSet([]uint{2, 3})
+func (u UserModel) FollowingSet(ids []uint) map[uint]bool {
+	db := common.GetDB()
+	following := make(map[uint]bool)
+	if u.ID == 0 || len(ids) == 0 {
+		return following
+	}
+	var followingIDs []uint
+	db.Model(&FollowModel{}).Where("followed_by_id = ? AND following_id IN (?)", u.ID, ids).Pluck("following_id", &followingIDs)
+	for _, id := range followingIDs {
+		following[id] = true
+	}
+	return following
+}
+
 // You could delete a following relationship as userModel1 following userModel2
 // 	err = userModel1.unFollowing(userModel2)
 func (u UserModel) unFollowing(v UserModel) error {
@@ -127,24 +212,336 @@ func (u UserModel) unFollowing(v UserModel) error {
 		FollowingID:  v.ID,
 		FollowedByID: u.ID,
 	}).Delete(FollowModel{}).Error
-	return err
+	if err == nil {
+		err = db.Where("requester_id = ? AND target_id = ?", u.ID, v.ID).Delete(FollowRequestModel{}).Error
+	}
+	return common.NormalizeDBError(err)
+}
+
+// userModel1 asks the private userModel2 to be followed, following waits for the answer.
+// 	err = userModel1.requestFollow(userModel2)
+func (u UserModel) requestFollow(v UserModel) error {
+	db := common.GetDB()
+	var request FollowRequestModel
+	err := db.FirstOrCreate(&request, &FollowRequestModel{RequesterID: u.ID, TargetID: v.ID}).Error
+	return common.NormalizeDBError(err)
+}
+
+func (u UserModel) hasRequested(v UserModel) bool {
+	db := common.GetDB()
+	var count int
+	// Spelled out, a struct condition would drop the zero id of anonymous users
+	db.Model(&FollowRequestModel{}).Where("requester_id = ? AND target_id = ?", u.ID, v.ID).Count(&count)
+	return count != 0
+}
+
+// A page of the follow requests u hasn't answered, latest first.
+func (u UserModel) getFollowRequests(page common.Page) ([]FollowRequestModel, int, common.Cursors, error) {
+	db := common.GetDB()
+	var requests []FollowRequestModel
+	var count int
+	query := db.Model(&FollowRequestModel{}).Where(FollowRequestModel{TargetID: u.ID})
+	query.Count(&count)
+	cursors, err := page.Find(query.Preload("Requester"), &requests, "", true)
+	return requests, count, cursors, err
+}
+
+// One of the requests to follow u, so nobody answers the requests of someone else.
+func (u UserModel) findFollowRequest(id uint) (FollowRequestModel, error) {
+	db := common.GetDB()
+	var request FollowRequestModel
+	err := db.Where(FollowRequestModel{ID: id, TargetID: u.ID}).Preload("Requester").First(&request).Error
+	return request, err
+}
+
+// Turn the requests into follows.
+func approveFollowRequests(tx *gorm.DB, requests []FollowRequestModel) error {
+	for _, request := range requests {
+		var follow FollowModel
+		if err := tx.FirstOrCreate(&follow, &FollowModel{FollowingID: request.TargetID, FollowedByID: request.RequesterID}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&request).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (request FollowRequestModel) approve() error {
+	db := common.GetDB()
+	tx := db.Begin()
+	if err := approveFollowRequests(tx, []FollowRequestModel{request}); err != nil {
+		tx.Rollback()
+		return common.NormalizeDBError(err)
+	}
+	return common.NormalizeDBError(tx.Commit().Error)
+}
+
+func (request FollowRequestModel) reject() error {
+	db := common.GetDB()
+	return common.NormalizeDBError(db.Delete(&request).Error)
+}
+
+// Make the account private or public again, going public approves whatever is pending.
+// Update can't do it, it skips false.
+func (model *UserModel) setPrivate(private bool) error {
+	db := common.GetDB()
+	tx := db.Begin()
+	err := tx.Model(model).Update("private", private).Error
+	if err == nil && !private {
+		var requests []FollowRequestModel
+		err = tx.Where(FollowRequestModel{TargetID: model.ID}).Find(&requests).Error
+		if err == nil {
+			err = approveFollowRequests(tx, requests)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return common.NormalizeDBError(err)
+	}
+	return common.NormalizeDBError(tx.Commit().Error)
+}
+
+// The ids of the private users whose articles u can't see, the ones u doesn't follow. u is
+// never one of them, and every private user is for nobody (ID 0).
+// 	query.Where("user_model_id NOT IN ?", myUserModel.PrivateUsers())
+func (u UserModel) PrivateUsers() *gorm.SqlExpr {
+	return gorm.Expr("(SELECT id FROM user_models WHERE private = ? AND id <> ? AND id NOT IN (SELECT following_id FROM follow_models WHERE followed_by_id = ? AND deleted_at IS NULL))", true, u.ID, u.ID)
 }
 
-// You could get a following list of userModel
+// You could get a following list of userModel, in the order they were followed
 // 	followings := userModel.GetFollowings()
 func (u UserModel) GetFollowings() []UserModel {
 	db := common.GetDB()
-	tx := db.Begin()
-	var follows []FollowModel
 	var followings []UserModel
-	tx.Where(FollowModel{
-		FollowedByID: u.ID,
-	}).Find(&follows)
+	db.Joins("JOIN follow_models ON follow_models.following_id = user_models.id AND follow_models.deleted_at IS NULL").
+		Where("follow_models.followed_by_id = ?", u.ID).Order("follow_models.id").Find(&followings)
+	return followings
+}
+
+// A page of the users following u, latest follow first.
+func (u UserModel) getFollowers(page common.Page) ([]UserModel, int, common.Cursors, error) {
+	return follows(page, "FollowedBy", FollowModel{FollowingID: u.ID})
+}
+
+// A page of the users u follows, latest follow first.
+func (u UserModel) getFollowings(page common.Page) ([]UserModel, int, common.Cursors, error) {
+	return follows(page, "Following", FollowModel{FollowedByID: u.ID})
+}
+
+// The follows matching the condition, paged by when they were made, and the user on the
+// given side of each.
+func follows(page common.Page, side string, condition FollowModel) ([]UserModel, int, common.Cursors, error) {
+	db := common.GetDB()
+	var follows []FollowModel
+	var count int
+	query := db.Model(&FollowModel{}).Where(condition)
+	query.Count(&count)
+	cursors, err := page.Find(query.Preload(side), &follows, "", true)
+	userModels := []UserModel{}
 	for _, follow := range follows {
-		var userModel UserModel
-		tx.Model(&follow).Related(&userModel, "Following")
-		followings = append(followings, userModel)
+		if side == "Following" {
+			userModels = append(userModels, follow.Following)
+		} else {
+			userModels = append(userModels, follow.FollowedBy)
+		}
 	}
-	tx.Commit()
-	return followings
+	return userModels, count, cursors, err
+}
+
+// How many followers and followings each of the users has, in two queries.
+// 	followers, followings := FollowCounts([]uint{2, 3})
+func FollowCounts(ids []uint) (map[uint]int, map[uint]int) {
+	db := common.GetDB()
+	count := func(column string) map[uint]int {
+		counts := make(map[uint]int)
+		if len(ids) == 0 {
+			return counts
+		}
+		rows, err := db.Model(&FollowModel{}).Select(column+", count(*)").Where(column+" IN (?)", ids).Group(column).Rows()
+		if err != nil {
+			return counts
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id uint
+			var n int
+			rows.Scan(&id, &n)
+			counts[id] = n
+		}
+		return counts
+	}
+	return count("following_id"), count("followed_by_id")
+}
+
+// userModel1 blocks userModel2, which also ends the follows and follow requests between them
+// either way.
+// 	err = userModel1.block(userModel2)
+func (u UserModel) block(v UserModel) error {
+	db := common.GetDB()
+	tx := db.Begin()
+	var block BlockModel
+	err := tx.FirstOrCreate(&block, &BlockModel{BlockerID: u.ID, BlockedID: v.ID}).Error
+	if err == nil {
+		err = tx.Where("(following_id = ? AND followed_by_id = ?) OR (following_id = ? AND followed_by_id = ?)", u.ID, v.ID, v.ID, u.ID).Delete(FollowModel{}).Error
+	}
+	if err == nil {
+		err = tx.Where("(requester_id = ? AND target_id = ?) OR (requester_id = ? AND target_id = ?)", u.ID, v.ID, v.ID, u.ID).Delete(FollowRequestModel{}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return common.NormalizeDBError(err)
+	}
+	return common.NormalizeDBError(tx.Commit().Error)
+}
+
+func (u UserModel) unblock(v UserModel) error {
+	db := common.GetDB()
+	err := db.Where("blocker_id = ? AND blocked_id = ?", u.ID, v.ID).Delete(BlockModel{}).Error
+	return common.NormalizeDBError(err)
+}
+
+// Whether userModel1 blocked userModel2
+// 	if author.IsBlocking(myUserModel) { ... }
+func (u UserModel) IsBlocking(v UserModel) bool {
+	db := common.GetDB()
+	var count int
+	// Spelled out, a struct condition would drop the zero id of anonymous users
+	db.Model(&BlockModel{}).Where("blocker_id = ? AND blocked_id = ?", u.ID, v.ID).Count(&count)
+	return count != 0
+}
+
+func (u UserModel) mute(v UserModel) error {
+	db := common.GetDB()
+	var mute MuteModel
+	err := db.FirstOrCreate(&mute, &MuteModel{MuterID: u.ID, MutedID: v.ID}).Error
+	return common.NormalizeDBError(err)
+}
+
+func (u UserModel) unmute(v UserModel) error {
+	db := common.GetDB()
+	err := db.Where("muter_id = ? AND muted_id = ?", u.ID, v.ID).Delete(MuteModel{}).Error
+	return common.NormalizeDBError(err)
+}
+
+func (u UserModel) isMuting(v UserModel) bool {
+	db := common.GetDB()
+	var count int
+	db.Model(&MuteModel{}).Where("muter_id = ? AND muted_id = ?", u.ID, v.ID).Count(&count)
+	return count != 0
+}
+
+// Whether u blocked or muted v, HiddenUsers for a single user.
+func (u UserModel) Hides(v UserModel) bool {
+	return u.IsBlocking(v) || u.isMuting(v)
+}
+
+// The ids of the users u blocked or muted, as a subquery for leaving their content out.
+// 	query.Where("user_model_id NOT IN ?", myUserModel.HiddenUsers())
+func (u UserModel) HiddenUsers() *gorm.SqlExpr {
+	return gorm.Expr("(SELECT blocked_id FROM block_models WHERE blocker_id = ? UNION SELECT muted_id FROM mute_models WHERE muter_id = ?)", u.ID, u.ID)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue a refresh token for the user, starting a new family unless one is given.
+// 	refreshToken, err := userModel.NewRefreshToken("")
+func (u UserModel) NewRefreshToken(family string) (string, error) {
+	db := common.GetDB()
+	if family == "" {
+		family = common.RandToken(16)
+	}
+	token := common.RandToken(32)
+	err := db.Create(&RefreshTokenModel{
+		UserModelID: u.ID,
+		TokenHash:   hashToken(token),
+		Family:      family,
+		ExpiresAt:   time.Now().Add(time.Duration(common.GetConfig().JWT.RefreshTTL)),
+	}).Error
+	return token, common.NormalizeDBError(err)
+}
+
+// Trade a refresh token for a new one, it returns the owner and the new token.
+// A token which was already used revokes every token of its family.
+// 	userModel, refreshToken, err := RotateRefreshToken(refreshToken)
+func RotateRefreshToken(token string) (UserModel, string, error) {
+	db := common.GetDB()
+	var model RefreshTokenModel
+	if err := db.Where(RefreshTokenModel{TokenHash: hashToken(token)}).First(&model).Error; err != nil {
+		return UserModel{}, "", errInvalidRefreshToken
+	}
+	if model.RevokedAt != nil {
+		revokeRefreshTokens(RefreshTokenModel{Family: model.Family})
+		return UserModel{}, "", errInvalidRefreshToken
+	}
+	if time.Now().After(model.ExpiresAt) {
+		return UserModel{}, "", errInvalidRefreshToken
+	}
+	// Only the request which flips revoked_at gets to rotate, a concurrent replay gets nothing.
+	now := time.Now()
+	result := db.Model(&RefreshTokenModel{}).Where("id = ? AND revoked_at IS NULL", model.ID).Update("revoked_at", now)
+	if result.Error != nil {
+		return UserModel{}, "", common.NormalizeDBError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		revokeRefreshTokens(RefreshTokenModel{Family: model.Family})
+		return UserModel{}, "", errInvalidRefreshToken
+	}
+	userModel, err := FindOneUser(&UserModel{ID: model.UserModelID})
+	if err != nil {
+		return UserModel{}, "", errInvalidRefreshToken
+	}
+	newToken, err := userModel.NewRefreshToken(model.Family)
+	return userModel, newToken, err
+}
+
+// Revoke the family of a refresh token, unknown tokens are ignored.
+// 	err := RevokeRefreshToken(refreshToken)
+func RevokeRefreshToken(token string) error {
+	db := common.GetDB()
+	var model RefreshTokenModel
+	if err := db.Where(RefreshTokenModel{TokenHash: hashToken(token)}).First(&model).Error; err != nil {
+		return nil
+	}
+	return revokeRefreshTokens(RefreshTokenModel{Family: model.Family})
+}
+
+func revokeRefreshTokens(condition RefreshTokenModel) error {
+	db := common.GetDB()
+	err := db.Model(&RefreshTokenModel{}).Where(condition).Where("revoked_at IS NULL").Update("revoked_at", time.Now()).Error
+	return common.NormalizeDBError(err)
+}
+
+// Put an access token on the denylist until it would have expired anyway.
+// 	err := RevokeAccessToken(jti, time.Unix(exp, 0))
+func RevokeAccessToken(jti string, expiresAt time.Time) error {
+	db := common.GetDB()
+	var model RevokedTokenModel
+	err := db.Where(RevokedTokenModel{JTI: jti}).Attrs(RevokedTokenModel{ExpiresAt: expiresAt}).FirstOrCreate(&model).Error
+	return common.NormalizeDBError(err)
+}
+
+// Whether an access token was revoked, AuthMiddleware asks on every request.
+func IsAccessTokenRevoked(jti string) bool {
+	db := common.GetDB()
+	var count int
+	db.Model(&RevokedTokenModel{}).Where(RevokedTokenModel{JTI: jti}).Count(&count)
+	return count != 0
+}
+
+// Delete denylist entries, refresh tokens and signing keys nobody can use any more.
+func PurgeExpiredTokens() error {
+	db := common.GetDB()
+	now := time.Now()
+	if err := db.Where("expires_at < ?", now).Delete(RevokedTokenModel{}).Error; err != nil {
+		return err
+	}
+	if err := common.PurgeRetiredSigningKeys(); err != nil {
+		return err
+	}
+	return db.Unscoped().Where("expires_at < ?", now).Delete(RefreshTokenModel{}).Error
 }
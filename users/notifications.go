@@ -0,0 +1,174 @@
+package users
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"realworld-backend/common"
+)
+
+// What a notification is about.
+const (
+	NotifyFollow   = "follow"   // someone followed the user, not when the user approved it
+	NotifyFavorite = "favorite" // someone favorited one of the user's articles
+	NotifyComment  = "comment"  // someone commented on one of the user's articles
+)
+
+// One notification of the user, for one or more actors doing the same thing to the same
+// article. Later actors join the notification as long as it is unread, so "Jake and 4
+// others favorited..." is a single row; once read, the next actor starts a new one.
+//
+// The article is copied in, users can't look articles up. Its old slug keeps working.
+type NotificationModel struct {
+	ID           uint   `gorm:"primary_key"`
+	UserID       uint   `gorm:"index"`
+	Kind         string `gorm:"size:16"`
+	ArticleID    uint   // 0 for follows
+	ArticleSlug  string
+	ArticleTitle string
+	Actor        UserModel // the latest of the actors
+	ActorID      uint
+	ActorsCount  int
+	ReadAt       *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time // when the latest actor joined
+}
+
+// Who is behind a notification, each actor counts once.
+type NotificationActorModel struct {
+	ID             uint `gorm:"primary_key"`
+	NotificationID uint `gorm:"unique_index:idx_notification_actor"`
+	ActorID        uint `gorm:"unique_index:idx_notification_actor"`
+	CreatedAt      time.Time
+}
+
+// Let the user of the notification know that actor did its kind of thing, to the article
+// of the notification if there is one. Nobody is told about their own doings or about
+// users they muted or blocked.
+//
+//	err := users.Notify(users.NotificationModel{UserID: 1, Kind: users.NotifyFollow}, myUserModel)
+func Notify(notification NotificationModel, actor UserModel) error {
+	user := UserModel{ID: notification.UserID}
+	if user.ID == actor.ID || user.isMuting(actor) || user.IsBlocking(actor) {
+		return nil
+	}
+	db := common.GetDB()
+	tx := db.Begin()
+	var model NotificationModel
+	err := tx.Where("user_id = ? AND kind = ? AND article_id = ? AND read_at IS NULL", notification.UserID, notification.Kind, notification.ArticleID).
+		Order("id DESC").First(&model).Error
+	if err != nil {
+		model = NotificationModel{
+			UserID:       notification.UserID,
+			Kind:         notification.Kind,
+			ArticleID:    notification.ArticleID,
+			ArticleSlug:  notification.ArticleSlug,
+			ArticleTitle: notification.ArticleTitle,
+		}
+		err = tx.Create(&model).Error
+	}
+	var count int
+	if err == nil {
+		err = tx.Model(&NotificationActorModel{}).Where(NotificationActorModel{NotificationID: model.ID, ActorID: actor.ID}).Count(&count).Error
+	}
+	if err == nil && count == 0 {
+		err = tx.Create(&NotificationActorModel{NotificationID: model.ID, ActorID: actor.ID}).Error
+		if err == nil {
+			err = tx.Model(&model).Updates(map[string]interface{}{
+				"actor_id":      actor.ID,
+				"actors_count":  gorm.Expr("actors_count + 1"),
+				"article_slug":  notification.ArticleSlug,
+				"article_title": notification.ArticleTitle,
+			}).Error
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return common.NormalizeDBError(err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return common.NormalizeDBError(err)
+	}
+	if count == 0 {
+		if grown, err := user.findNotification(model.ID); err == nil {
+			common.GetHub().Publish(NotificationsTopic(user.ID), "notification", grown)
+		}
+	}
+	return nil
+}
+
+// The hub topic of the notifications of the user, each new or grown notification is sent
+// there as it is now.
+func NotificationsTopic(userID uint) string {
+	return fmt.Sprintf("users/%v/notifications", userID)
+}
+
+// A page of u's notifications, the latest activity first, only the unread ones if asked.
+func (u UserModel) getNotifications(unread bool, page common.Page) ([]NotificationModel, int, common.Cursors, error) {
+	db := common.GetDB()
+	var models []NotificationModel
+	var count int
+	query := db.Model(&NotificationModel{}).Where("user_id = ?", u.ID)
+	if unread {
+		query = query.Where("read_at IS NULL")
+	}
+	query.Count(&count)
+	cursors, err := page.Find(query.Preload("Actor"), &models, "UpdatedAt", true)
+	return models, count, cursors, err
+}
+
+func (u UserModel) unreadNotificationsCount() int {
+	db := common.GetDB()
+	var count int
+	db.Model(&NotificationModel{}).Where("user_id = ? AND read_at IS NULL", u.ID).Count(&count)
+	return count
+}
+
+// One of u's notifications, so nobody reads the notifications of someone else.
+func (u UserModel) findNotification(id uint) (NotificationModel, error) {
+	db := common.GetDB()
+	var model NotificationModel
+	err := db.Where(NotificationModel{ID: id, UserID: u.ID}).Preload("Actor").First(&model).Error
+	return model, err
+}
+
+// Mark the notification read, reading it again keeps the first time.
+func (model *NotificationModel) markRead() error {
+	if model.ReadAt != nil {
+		return nil
+	}
+	db := common.GetDB()
+	now := time.Now()
+	// UpdateColumn, reading is no activity and mustn't move the notification up the list
+	err := db.Model(model).UpdateColumn("read_at", now).Error
+	return common.NormalizeDBError(err)
+}
+
+// Mark every unread notification of u read, it returns how many there were.
+func (u UserModel) markAllNotificationsRead() (int64, error) {
+	db := common.GetDB()
+	result := db.Model(&NotificationModel{}).Where("user_id = ? AND read_at IS NULL", u.ID).UpdateColumn("read_at", time.Now())
+	return result.RowsAffected, common.NormalizeDBError(result.Error)
+}
+
+// What the notification says, e.g. `jake and 4 others favorited your article "Hello"`.
+func (model NotificationModel) message() string {
+	who := model.Actor.Username
+	switch others := model.ActorsCount - 1; {
+	case others == 1:
+		who += " and 1 other"
+	case others > 1:
+		who += fmt.Sprintf(" and %v others", others)
+	}
+	switch model.Kind {
+	case NotifyFollow:
+		return who + " followed you"
+	case NotifyFavorite:
+		return fmt.Sprintf("%v favorited your article \"%v\"", who, model.ArticleTitle)
+	case NotifyComment:
+		return fmt.Sprintf("%v commented on your article \"%v\"", who, model.ArticleTitle)
+	}
+	return who
+}
@@ -2,30 +2,54 @@ package users
 
 import (
 	"errors"
+	"github.com/dgrijalva/jwt-go"
 	"realworld-backend/common"
 	"github.com/gin-gonic/gin"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func UsersRegister(router *gin.RouterGroup) {
 	router.POST("/", UsersRegistration)
 	router.POST("/login", UsersLogin)
+	router.POST("/token/refresh", UsersTokenRefresh)
+	router.POST("/logout", AuthMiddleware(true), UsersLogout)
+}
+
+// Mounted at /.well-known, so services verifying our access tokens can fetch the keys.
+func JWKSRegister(router *gin.RouterGroup) {
+	router.GET("/jwks.json", JWKSRetrieve)
 }
 
 func UserRegister(router *gin.RouterGroup) {
 	router.GET("/", UserRetrieve)
 	router.PUT("/", UserUpdate)
+	router.GET("/follow-requests", FollowRequestList)
+	router.POST("/follow-requests/:id", FollowRequestApprove)
+	router.DELETE("/follow-requests/:id", FollowRequestReject)
+	router.GET("/notifications", NotificationList)
+	router.GET("/notifications/stream", NotificationStream)
+	router.POST("/notifications/read", NotificationReadAll)
+	router.POST("/notifications/:id/read", NotificationRead)
 }
 
 func ProfileRegister(router *gin.RouterGroup) {
 	router.GET("/:username", ProfileRetrieve)
 	router.POST("/:username/follow", ProfileFollow)
 	router.DELETE("/:username/follow", ProfileUnfollow)
+	router.POST("/:username/block", ProfileBlock)
+	router.DELETE("/:username/block", ProfileUnblock)
+	router.POST("/:username/mute", ProfileMute)
+	router.DELETE("/:username/mute", ProfileUnmute)
+	router.GET("/:username/followers", ProfileFollowers)
+	router.GET("/:username/following", ProfileFollowings)
 }
 
 func ProfileRetrieve(c *gin.Context) {
 	username := c.Param("username")
-	userModel, err := FindOneUser(&UserModel{Username: username})
+	userModel, err := FindOneUser(common.LowerEqual("username"), username)
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
 		return
@@ -36,13 +60,32 @@ func ProfileRetrieve(c *gin.Context) {
 
 func ProfileFollow(c *gin.Context) {
 	username := c.Param("username")
-	userModel, err := FindOneUser(&UserModel{Username: username})
+	userModel, err := FindOneUser(common.LowerEqual("username"), username)
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(UserModel)
-	err = myUserModel.following(userModel)
+	if userModel.IsBlocking(myUserModel) {
+		c.JSON(http.StatusForbidden, common.NewError("profile", errors.New("You are blocked")))
+		return
+	}
+	if myUserModel.IsBlocking(userModel) {
+		c.JSON(http.StatusForbidden, common.NewError("profile", errors.New("Unblock the user first")))
+		return
+	}
+	followed := myUserModel.isFollowing(userModel)
+	if userModel.Private && userModel.ID != myUserModel.ID && !followed {
+		err = myUserModel.requestFollow(userModel)
+	} else {
+		err = myUserModel.following(userModel)
+		// Following again changes nothing and tells nobody, like favoriting again
+		if err == nil && !followed {
+			if err := Notify(NotificationModel{UserID: userModel.ID, Kind: NotifyFollow}, myUserModel); err != nil {
+				log.Println("notify:", err)
+			}
+		}
+	}
 	if err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
@@ -53,7 +96,7 @@ func ProfileFollow(c *gin.Context) {
 
 func ProfileUnfollow(c *gin.Context) {
 	username := c.Param("username")
-	userModel, err := FindOneUser(&UserModel{Username: username})
+	userModel, err := FindOneUser(common.LowerEqual("username"), username)
 	if err != nil {
 		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
 		return
@@ -69,6 +112,73 @@ func ProfileUnfollow(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"profile": serializer.Response()})
 }
 
+func ProfileBlock(c *gin.Context) {
+	profileRelation(c, UserModel.block)
+}
+
+func ProfileUnblock(c *gin.Context) {
+	profileRelation(c, UserModel.unblock)
+}
+
+func ProfileMute(c *gin.Context) {
+	profileRelation(c, UserModel.mute)
+}
+
+func ProfileUnmute(c *gin.Context) {
+	profileRelation(c, UserModel.unmute)
+}
+
+// Block, mute, or undo either, from the current user to :username.
+func profileRelation(c *gin.Context, change func(UserModel, UserModel) error) {
+	username := c.Param("username")
+	userModel, err := FindOneUser(common.LowerEqual("username"), username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	if userModel.ID == myUserModel.ID {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("profile", errors.New("That is you")))
+		return
+	}
+	if err := change(myUserModel, userModel); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := ProfileSerializer{c, userModel}
+	c.JSON(http.StatusOK, gin.H{"profile": serializer.Response()})
+}
+
+func ProfileFollowers(c *gin.Context) {
+	profileFollows(c, UserModel.getFollowers)
+}
+
+func ProfileFollowings(c *gin.Context) {
+	profileFollows(c, UserModel.getFollowings)
+}
+
+// Answer with a page of the followers or followings of :username.
+func profileFollows(c *gin.Context, list func(UserModel, common.Page) ([]UserModel, int, common.Cursors, error)) {
+	username := c.Param("username")
+	userModel, err := FindOneUser(common.LowerEqual("username"), username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
+		return
+	}
+	page, key, err := common.ParsePage(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
+	userModels, modelCount, cursors, err := list(userModel, page)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("profiles", errors.New("Database error")))
+		return
+	}
+	serializer := ProfilesSerializer{c, userModels}
+	c.JSON(http.StatusOK, gin.H{"profiles": serializer.Response(), "profilesCount": modelCount, "nextCursor": cursors.Next, "prevCursor": cursors.Prev})
+}
+
 func UsersRegistration(c *gin.Context) {
 	userModelValidator := NewUserModelValidator()
 	if err := userModelValidator.Bind(c); err != nil {
@@ -80,9 +190,20 @@ func UsersRegistration(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
+	refreshToken, err := userModelValidator.userModel.NewRefreshToken("")
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
 	c.Set("my_user_model", userModelValidator.userModel)
 	serializer := UserSerializer{c}
-	c.JSON(http.StatusCreated, gin.H{"user": serializer.Response()})
+	response, err := serializer.Response()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewError("token", err))
+		return
+	}
+	response.RefreshToken = refreshToken
+	c.JSON(http.StatusCreated, gin.H{"user": response})
 }
 
 func UsersLogin(c *gin.Context) {
@@ -91,7 +212,7 @@ func UsersLogin(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
 		return
 	}
-	userModel, err := FindOneUser(&UserModel{Email: loginValidator.userModel.Email})
+	userModel, err := FindOneUser(common.LowerEqual("email"), loginValidator.userModel.Email)
 
 	if err != nil {
 		c.JSON(http.StatusForbidden, common.NewError("login", errors.New("Not Registered email or invalid password")))
@@ -102,14 +223,81 @@ func UsersLogin(c *gin.Context) {
 		c.JSON(http.StatusForbidden, common.NewError("login", errors.New("Not Registered email or invalid password")))
 		return
 	}
+	refreshToken, err := userModel.NewRefreshToken("")
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	UpdateContextUserModel(c, userModel.ID)
+	serializer := UserSerializer{c}
+	response, err := serializer.Response()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewError("token", err))
+		return
+	}
+	response.RefreshToken = refreshToken
+	c.JSON(http.StatusOK, gin.H{"user": response})
+}
+
+// Trade a refresh token for a new access token and a new refresh token.
+func UsersTokenRefresh(c *gin.Context) {
+	refreshTokenValidator := NewRefreshTokenValidator()
+	if err := refreshTokenValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	userModel, refreshToken, err := RotateRefreshToken(refreshTokenValidator.RefreshToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, common.NewError("token", err))
+		return
+	}
 	UpdateContextUserModel(c, userModel.ID)
 	serializer := UserSerializer{c}
-	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
+	response, err := serializer.Response()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewError("token", err))
+		return
+	}
+	response.RefreshToken = refreshToken
+	c.JSON(http.StatusOK, gin.H{"user": response})
+}
+
+// Revoke the access token of the request, and the refresh token if the body has one.
+func UsersLogout(c *gin.Context) {
+	if c.Request.ContentLength > 0 {
+		refreshTokenValidator := NewRefreshTokenValidator()
+		if err := refreshTokenValidator.Bind(c); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+			return
+		}
+		if err := RevokeRefreshToken(refreshTokenValidator.RefreshToken); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+			return
+		}
+	}
+	claims := c.MustGet("my_token_claims").(jwt.MapClaims)
+	exp, _ := claims["exp"].(float64)
+	if err := RevokeAccessToken(claims["jti"].(string), time.Unix(int64(exp), 0)); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": "Logout success"})
+}
+
+func JWKSRetrieve(c *gin.Context) {
+	// Short enough that a rotated key is picked up well within the grace window.
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, common.JWKS())
 }
 
 func UserRetrieve(c *gin.Context) {
 	serializer := UserSerializer{c}
-	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
+	response, err := serializer.Response()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewError("token", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": response})
 }
 
 func UserUpdate(c *gin.Context) {
@@ -125,7 +313,116 @@ func UserUpdate(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
+	if private := userModelValidator.userModel.Private; private != myUserModel.Private {
+		if err := myUserModel.setPrivate(private); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+			return
+		}
+	}
 	UpdateContextUserModel(c, myUserModel.ID)
 	serializer := UserSerializer{c}
-	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
+	response, err := serializer.Response()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewError("token", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": response})
+}
+
+func FollowRequestList(c *gin.Context) {
+	page, key, err := common.ParsePage(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	requests, count, cursors, err := myUserModel.getFollowRequests(page)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("followRequests", errors.New("Database error")))
+		return
+	}
+	serializer := FollowRequestsSerializer{c, requests}
+	c.JSON(http.StatusOK, gin.H{"followRequests": serializer.Response(), "followRequestsCount": count, "nextCursor": cursors.Next, "prevCursor": cursors.Prev})
+}
+
+func FollowRequestApprove(c *gin.Context) {
+	answerFollowRequest(c, FollowRequestModel.approve)
+}
+
+func FollowRequestReject(c *gin.Context) {
+	answerFollowRequest(c, FollowRequestModel.reject)
+}
+
+// Approve or reject the current user's follow request :id, and answer with the requester.
+func answerFollowRequest(c *gin.Context, answer func(FollowRequestModel) error) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	var request FollowRequestModel
+	if err == nil {
+		request, err = myUserModel.findFollowRequest(uint(id))
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("followRequest", errors.New("Invalid id")))
+		return
+	}
+	if err := answer(request); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := ProfileSerializer{c, request.Requester}
+	c.JSON(http.StatusOK, gin.H{"profile": serializer.Response()})
+}
+
+func NotificationList(c *gin.Context) {
+	page, key, err := common.ParsePage(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError(key, err))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	notifications, count, cursors, err := myUserModel.getNotifications(c.Query("unread") == "true", page)
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("notifications", errors.New("Database error")))
+		return
+	}
+	serializer := NotificationsSerializer{c, notifications}
+	c.JSON(http.StatusOK, gin.H{"notifications": serializer.Response(), "notificationsCount": count, "unreadCount": myUserModel.unreadNotificationsCount(), "nextCursor": cursors.Next, "prevCursor": cursors.Prev})
+}
+
+func NotificationRead(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	var notification NotificationModel
+	if err == nil {
+		notification, err = myUserModel.findNotification(uint(id))
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("notification", errors.New("Invalid id")))
+		return
+	}
+	if err := notification.markRead(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := NotificationSerializer{c, notification}
+	c.JSON(http.StatusOK, gin.H{"notification": serializer.Response(), "unreadCount": myUserModel.unreadNotificationsCount()})
+}
+
+func NotificationReadAll(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	read, err := myUserModel.markAllNotificationsRead()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"read": read, "unreadCount": 0})
+}
+
+// Server-sent events of the current user's notifications as they come or grow.
+func NotificationStream(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	common.Stream(c, NotificationsTopic(myUserModel.ID), func(event common.Event) (interface{}, bool) {
+		serializer := NotificationSerializer{c, event.Data.(NotificationModel)}
+		return serializer.Response(), true
+	})
 }
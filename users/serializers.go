@@ -18,17 +18,68 @@ type ProfileResponse struct {
 	Bio       string  `json:"bio"`
 	Image     *string `json:"image"`
 	Following bool    `json:"following"`
+	Private   bool    `json:"private,omitempty"`
+	// Only on the profile endpoints, article authors and such go without.
+	FollowersCount *int  `json:"followersCount,omitempty"`
+	FollowingCount *int  `json:"followingCount,omitempty"`
+	Blocking       *bool `json:"blocking,omitempty"` // whether the current user blocked this one
+	Muting         *bool `json:"muting,omitempty"`
+	Requested      bool  `json:"requested,omitempty"` // asked to follow this private user, no answer yet
 }
 
 // Put your response logic including wrap the userModel here.
 func (self *ProfileSerializer) Response() ProfileResponse {
 	myUserModel := self.C.MustGet("my_user_model").(UserModel)
+	profile := self.ResponseFollowing(myUserModel.isFollowing(self.UserModel))
+	profile.setCounts(FollowCounts([]uint{self.ID}))
+	if myUserModel.ID != 0 {
+		blocking, muting := myUserModel.IsBlocking(self.UserModel), myUserModel.isMuting(self.UserModel)
+		profile.Blocking, profile.Muting = &blocking, &muting
+	}
+	if self.Private && !profile.Following {
+		profile.Requested = myUserModel.hasRequested(self.UserModel)
+	}
+	return profile
+}
+
+func (profile *ProfileResponse) setCounts(followers, followings map[uint]int) {
+	followersCount, followingCount := followers[profile.ID], followings[profile.ID]
+	profile.FollowersCount, profile.FollowingCount = &followersCount, &followingCount
+}
+
+type ProfilesSerializer struct {
+	C     *gin.Context
+	Users []UserModel
+}
+
+func (self *ProfilesSerializer) Response() []ProfileResponse {
+	response := []ProfileResponse{}
+	myUserModel := self.C.MustGet("my_user_model").(UserModel)
+	var ids []uint
+	for _, userModel := range self.Users {
+		ids = append(ids, userModel.ID)
+	}
+	following := myUserModel.FollowingSet(ids)
+	followers, followings := FollowCounts(ids)
+	for _, userModel := range self.Users {
+		serializer := ProfileSerializer{self.C, userModel}
+		profile := serializer.ResponseFollowing(following[userModel.ID])
+		profile.setCounts(followers, followings)
+		response = append(response, profile)
+	}
+	return response
+}
+
+// The same response when whether the current user follows this one is already known,
+// e.g. looked up for a whole page with UserModel.FollowingSet.
+func (self *ProfileSerializer) ResponseFollowing(following bool) ProfileResponse {
 	profile := ProfileResponse{
 		ID:        self.ID,
 		Username:  self.Username,
 		Bio:       self.Bio,
 		Image:     self.Image,
-		Following: myUserModel.isFollowing(self.UserModel),
+		Following: following,
+		Private:   self.Private,
 	}
 	return profile
 }
@@ -43,16 +94,120 @@ type UserResponse struct {
 	Bio      string  `json:"bio"`
 	Image    *string `json:"image"`
 	Token    string  `json:"token"`
+	Private  bool    `json:"private,omitempty"`
+	// Only set by login, registration and token refresh.
+	RefreshToken string `json:"refreshToken,omitempty"`
 }
 
-func (self *UserSerializer) Response() UserResponse {
+// The signed in user with a new access token, an error if the token can't be signed.
+func (self *UserSerializer) Response() (UserResponse, error) {
 	myUserModel := self.c.MustGet("my_user_model").(UserModel)
+	token, err := common.NewToken(myUserModel.ID)
+	if err != nil {
+		return UserResponse{}, err
+	}
 	user := UserResponse{
 		Username: myUserModel.Username,
 		Email:    myUserModel.Email,
 		Bio:      myUserModel.Bio,
 		Image:    myUserModel.Image,
-		Token:    common.GenToken(myUserModel.ID),
+		Token:    token,
+		Private:  myUserModel.Private,
+	}
+	return user, nil
+}
+
+type FollowRequestsSerializer struct {
+	C        *gin.Context
+	Requests []FollowRequestModel
+}
+
+type FollowRequestResponse struct {
+	ID        uint            `json:"id"`
+	Profile   ProfileResponse `json:"profile"`
+	CreatedAt string          `json:"createdAt"`
+}
+
+func (self *FollowRequestsSerializer) Response() []FollowRequestResponse {
+	response := []FollowRequestResponse{}
+	var requesters []UserModel
+	for _, request := range self.Requests {
+		requesters = append(requesters, request.Requester)
+	}
+	serializer := ProfilesSerializer{self.C, requesters}
+	for i, profile := range serializer.Response() {
+		request := self.Requests[i]
+		response = append(response, FollowRequestResponse{
+			ID:        request.ID,
+			Profile:   profile,
+			CreatedAt: request.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+		})
+	}
+	return response
+}
+
+type NotificationSerializer struct {
+	C *gin.Context
+	NotificationModel
+}
+
+type NotificationArticleResponse struct {
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+}
+
+type NotificationResponse struct {
+	ID          uint                         `json:"id"`
+	Kind        string                       `json:"kind"`
+	Message     string                       `json:"message"`
+	Actor       ProfileResponse              `json:"actor"` // the latest one
+	ActorsCount int                          `json:"actorsCount"`
+	Article     *NotificationArticleResponse `json:"article"` // null for follows
+	Read        bool                         `json:"read"`
+	CreatedAt   string                       `json:"createdAt"`
+	UpdatedAt   string                       `json:"updatedAt"`
+}
+
+func (self *NotificationSerializer) Response() NotificationResponse {
+	myUserModel := self.C.MustGet("my_user_model").(UserModel)
+	actor := ProfileSerializer{self.C, self.Actor}
+	return self.response(actor.ResponseFollowing(myUserModel.isFollowing(self.Actor)))
+}
+
+func (self *NotificationSerializer) response(actor ProfileResponse) NotificationResponse {
+	notification := NotificationResponse{
+		ID:          self.ID,
+		Kind:        self.Kind,
+		Message:     self.message(),
+		Actor:       actor,
+		ActorsCount: self.ActorsCount,
+		Read:        self.ReadAt != nil,
+		CreatedAt:   self.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+		UpdatedAt:   self.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+	}
+	if self.ArticleID != 0 {
+		notification.Article = &NotificationArticleResponse{self.ArticleSlug, self.ArticleTitle}
+	}
+	return notification
+}
+
+type NotificationsSerializer struct {
+	C             *gin.Context
+	Notifications []NotificationModel
+}
+
+func (self *NotificationsSerializer) Response() []NotificationResponse {
+	response := []NotificationResponse{}
+	myUserModel := self.C.MustGet("my_user_model").(UserModel)
+	var ids []uint
+	for _, notification := range self.Notifications {
+		ids = append(ids, notification.ActorID)
+	}
+	following := myUserModel.FollowingSet(ids)
+	for _, notification := range self.Notifications {
+		serializer := NotificationSerializer{self.C, notification}
+		actor := ProfileSerializer{self.C, notification.Actor}
+		response = append(response, serializer.response(actor.ResponseFollowing(following[notification.ActorID])))
 	}
-	return user
+	return response
 }
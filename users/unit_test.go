@@ -4,15 +4,24 @@ import (
 	"github.com/stretchr/testify/assert"
 	"testing"
 
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/jinzhu/gorm"
 	"realworld-backend/common"
 	"github.com/gin-gonic/gin"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	_ "regexp"
+	"strings"
+	"time"
 )
 
 var image_url = "https://golang.org/doc/gopher/frontpage.png"
@@ -125,7 +134,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"username": "wangzitian0","email": "wzt@gg.cn","password": "jakejxke"}}`,
 		http.StatusCreated,
-		`{"user":{"username":"wangzitian0","email":"wzt@gg.cn","bio":"","image":null,"token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"wangzitian0","email":"wzt@gg.cn","bio":"","image":null,"token":"([a-zA-Z0-9-_.]{512})","refreshToken":"([a-zA-Z0-9-_]{43})"}}`,
 		"valid data and should return StatusCreated",
 	},
 	{
@@ -137,6 +146,24 @@ var unauthRequestTests = []struct {
 		`{"errors":{"database":"UNIQUE constraint failed: user_models.email"}}`,
 		"duplicated data and should return StatusUnprocessableEntity",
 	},
+	{
+		func(req *http.Request) {},
+		"/users/",
+		"POST",
+		`{"user":{"username": "WangZiTian0","email": "other@gg.cn","password": "jakejxke"}}`,
+		http.StatusUnprocessableEntity,
+		`{"errors":{"database":"UNIQUE constraint failed: user_models.username"}}`,
+		"username differing only in case should return StatusUnprocessableEntity",
+	},
+	{
+		func(req *http.Request) {},
+		"/users/",
+		"POST",
+		`{"user":{"username": "other","email": "WZT@gg.cn","password": "jakejxke"}}`,
+		http.StatusUnprocessableEntity,
+		`{"errors":{"database":"UNIQUE constraint failed: user_models.email"}}`,
+		"email differing only in case should return StatusUnprocessableEntity",
+	},
 	{
 		func(req *http.Request) {},
 		"/users/",
@@ -174,9 +201,18 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"email": "user1@linkedin.com","password": "password123"}}`,
 		http.StatusOK,
-		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{512})","refreshToken":"([a-zA-Z0-9-_]{43})"}}`,
 		"right info login should return user",
 	},
+	{
+		func(req *http.Request) {},
+		"/users/login",
+		"POST",
+		`{"user":{"email": "USER1@LinkedIn.com","password": "password123"}}`,
+		http.StatusOK,
+		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{512})","refreshToken":"([a-zA-Z0-9-_]{43})"}}`,
+		"login email should be case-insensitive",
+	},
 	{
 		func(req *http.Request) {},
 		"/users/login",
@@ -245,7 +281,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{512})"}}`,
 		"request should return current user with token",
 	},
 
@@ -259,7 +295,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"followersCount":0,"followingCount":0,"blocking":false,"muting":false}}`,
 		"request should return self profile",
 	},
 	{
@@ -270,9 +306,20 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"followersCount":0,"followingCount":0,"blocking":false,"muting":false}}`,
 		"request should return correct other's profile",
 	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/User1",
+		"GET",
+		``,
+		http.StatusOK,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"followersCount":0,"followingCount":0,"blocking":false,"muting":false}}`,
+		"profile username should be case-insensitive",
+	},
 
 	//---------------------   Testing for users' profile update   ---------------------
 	{
@@ -295,7 +342,7 @@ var unauthRequestTests = []struct {
 		"PUT",
 		`{"user":{"username":"user123","password": "password126","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg"}}`,
 		http.StatusOK,
-		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{512})"}}`,
 		"current user profile should be changed",
 	},
 	{
@@ -306,7 +353,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user123","bio":"bio123","image":"http://hehe/123.jpg","following":false}}`,
+		`{"profile":{"username":"user123","bio":"bio123","image":"http://hehe/123.jpg","following":false,"followersCount":0,"followingCount":0,"blocking":false,"muting":false}}`,
 		"request should return self profile after changed",
 	},
 	{
@@ -315,7 +362,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"email": "user123@linkedin.com","password": "password126"}}`,
 		http.StatusOK,
-		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{512})","refreshToken":"([a-zA-Z0-9-_]{43})"}}`,
 		"user should login using new password after changed",
 	},
 	{
@@ -415,7 +462,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":true}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":true,"followersCount":1,"followingCount":0,"blocking":false,"muting":false}}`,
 		"user follow another should work",
 	},
 	{
@@ -426,7 +473,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":true}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":true,"followersCount":1,"followingCount":0,"blocking":false,"muting":false}}`,
 		"user follow another should make sure database changed",
 	},
 	{
@@ -437,7 +484,7 @@ var unauthRequestTests = []struct {
 		"DELETE",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"followersCount":0,"followingCount":0,"blocking":false,"muting":false}}`,
 		"user cancel follow another should work",
 	},
 	{
@@ -448,7 +495,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"followersCount":0,"followingCount":0,"blocking":false,"muting":false}}`,
 		"user cancel follow another should make sure database changed",
 	},
 }
@@ -479,6 +526,803 @@ func TestWithoutAuth(t *testing.T) {
 	}
 }
 
+func TestTokenRefreshAndLogout(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+
+	r := gin.New()
+	UsersRegister(r.Group("/users"))
+	r.Use(AuthMiddleware(true))
+	UserRegister(r.Group("/user"))
+
+	request := func(method, url, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	tokens := func(w *httptest.ResponseRecorder) (string, string) {
+		var response struct {
+			User UserResponse `json:"user"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.User.Token, response.User.RefreshToken
+	}
+
+	w := request("POST", "/users/login", "", `{"user":{"email": "user1@linkedin.com","password": "password123"}}`)
+	asserts.Equal(http.StatusOK, w.Code, "login should work")
+	token1, refresh1 := tokens(w)
+	asserts.Equal(http.StatusOK, request("GET", "/user/", token1, "").Code, "access token should work")
+
+	w = request("POST", "/users/token/refresh", "", fmt.Sprintf(`{"refreshToken":"%v"}`, refresh1))
+	asserts.Equal(http.StatusOK, w.Code, "refresh token should be traded for new tokens")
+	token2, refresh2 := tokens(w)
+	asserts.NotEqual(refresh1, refresh2, "refresh token should rotate")
+	asserts.NotEqual(token1, token2, "access token should be new")
+	asserts.Equal(http.StatusOK, request("GET", "/user/", token2, "").Code, "refreshed access token should work")
+
+	w = request("POST", "/users/token/refresh", "", fmt.Sprintf(`{"refreshToken":"%v"}`, refresh1))
+	asserts.Equal(http.StatusUnauthorized, w.Code, "used refresh token should be rejected")
+	asserts.Equal(`{"errors":{"token":"Invalid or expired refresh token"}}`, w.Body.String())
+	w = request("POST", "/users/token/refresh", "", fmt.Sprintf(`{"refreshToken":"%v"}`, refresh2))
+	asserts.Equal(http.StatusUnauthorized, w.Code, "reusing a refresh token should revoke its whole family")
+
+	w = request("POST", "/users/token/refresh", "", `{}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "refresh without token should return error")
+
+	w = request("POST", "/users/login", "", `{"user":{"email": "user1@linkedin.com","password": "password123"}}`)
+	token3, refresh3 := tokens(w)
+	asserts.Equal(http.StatusUnauthorized, request("POST", "/users/logout", "", "").Code, "logout should require auth")
+	w = request("POST", "/users/logout", token3, fmt.Sprintf(`{"refreshToken":"%v"}`, refresh3))
+	asserts.Equal(http.StatusOK, w.Code, "logout should work")
+	asserts.Equal(`{"user":"Logout success"}`, w.Body.String())
+	asserts.Equal(http.StatusUnauthorized, request("GET", "/user/", token3, "").Code, "access token should be revoked by logout")
+	asserts.Equal(http.StatusUnauthorized, request("POST", "/users/token/refresh", "", fmt.Sprintf(`{"refreshToken":"%v"}`, refresh3)).Code, "refresh token should be revoked by logout")
+	asserts.Equal(http.StatusOK, request("GET", "/user/", token2, "").Code, "other sessions should survive logout")
+
+	userModel, _ := FindOneUser(&UserModel{ID: 2})
+	refresh4, _ := userModel.NewRefreshToken("")
+	test_db.Model(&RefreshTokenModel{}).Where(RefreshTokenModel{TokenHash: hashToken(refresh4)}).Update("expires_at", time.Now().Add(-time.Minute))
+	asserts.Equal(http.StatusUnauthorized, request("POST", "/users/token/refresh", "", fmt.Sprintf(`{"refreshToken":"%v"}`, refresh4)).Code, "expired refresh token should be rejected")
+	asserts.NoError(PurgeExpiredTokens())
+	var count int
+	test_db.Model(&RefreshTokenModel{}).Unscoped().Where(RefreshTokenModel{TokenHash: hashToken(refresh4)}).Count(&count)
+	asserts.Equal(0, count, "expired refresh token should be purged")
+
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 1, "exp": time.Now().Add(time.Hour).Unix()})
+	legacyToken, _ := legacy.SignedString([]byte(common.GetConfig().JWT.Secret))
+	asserts.Equal(http.StatusUnauthorized, request("GET", "/user/", legacyToken, "").Code, "token without jti should be rejected")
+}
+
+func TestJWKS(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+
+	r := gin.New()
+	JWKSRegister(r.Group("/.well-known"))
+	r.Use(AuthMiddleware(true))
+	UserRegister(r.Group("/user"))
+
+	request := func(url, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	jwks := func() common.JSONWebKeySet {
+		w := request("/.well-known/jwks.json", "")
+		asserts.Equal(http.StatusOK, w.Code, "JWKS should be public")
+		var set common.JSONWebKeySet
+		json.Unmarshal(w.Body.Bytes(), &set)
+		return set
+	}
+
+	oldToken := common.GenToken(1)
+	set := jwks()
+	// Verify the token the way another service would, only from the published key.
+	_, err := jwt.Parse(oldToken, func(token *jwt.Token) (interface{}, error) {
+		for _, key := range set.Keys {
+			if key.KeyID == token.Header["kid"] {
+				n, _ := base64.RawURLEncoding.DecodeString(key.N)
+				e, _ := base64.RawURLEncoding.DecodeString(key.E)
+				return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+			}
+		}
+		return nil, fmt.Errorf("no key %v", token.Header["kid"])
+	})
+	asserts.NoError(err, "token should verify with the published key")
+
+	asserts.NoError(common.RotateSigningKey())
+	asserts.Len(jwks().Keys, len(set.Keys)+1, "rotated key should be published next to the old one")
+	asserts.Equal(http.StatusOK, request("/user/", oldToken).Code, "token of the previous key should still be accepted")
+	asserts.Equal(http.StatusOK, request("/user/", common.GenToken(1)).Code, "token of the new key should be accepted")
+}
+
+var followListRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/follow", "POST", http.StatusOK,
+		`"following":true,"followersCount":1,"followingCount":0,"blocking":false,"muting":false}}`,
+		"follow should count the new follower",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/profiles/user1/follow", "POST", http.StatusOK,
+		`"following":true,"followersCount":2,"followingCount":0,"blocking":false,"muting":false}}`,
+		"followers should add up",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user3/follow", "POST", http.StatusOK,
+		`"following":true,"followersCount":1,"followingCount":1,"blocking":false,"muting":false}}`,
+		"followed user should count their followings too",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user1/followers", "GET", http.StatusOK,
+		`{"nextCursor":null,"prevCursor":null,"profiles":\[{"username":"user3","bio":"bio3","image":"http://image/3.jpg","following":true,"followersCount":1,"followingCount":1},{"username":"user2","bio":"bio2","image":"http://image/2.jpg","following":false,"followersCount":0,"followingCount":1}\],"profilesCount":2}`,
+		"followers should be listed, latest first",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user1/followers?limit=1", "GET", http.StatusOK,
+		`{"nextCursor":"[^"]+","prevCursor":null,"profiles":\[{"username":"user3",[^\]]*\],"profilesCount":2}`,
+		"followers should be paged",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/USER1/following", "GET", http.StatusOK,
+		`"profiles":\[{"username":"user3","bio":"bio3","image":"http://image/3.jpg","following":false,"followersCount":1,"followingCount":1}\],"profilesCount":1}`,
+		"followings should be listed",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user2/followers", "GET", http.StatusOK,
+		`"profiles":\[\],"profilesCount":0}`,
+		"user without followers should have an empty list",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/nobody/followers", "GET", http.StatusNotFound,
+		`{"errors":{"profile":"Invalid username"}}`,
+		"unknown user should have no followers",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/following?limit=x", "GET", http.StatusUnprocessableEntity,
+		`{"errors":{"limit":`,
+		"bad page should be rejected",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1", "GET", http.StatusOK,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":true,"followersCount":2,"followingCount":1,"blocking":false,"muting":false}}`,
+		"profile should have its counts",
+	},
+}
+
+func TestFollowLists(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(true))
+	ProfileRegister(r.Group("/profiles"))
+	for _, testData := range followListRequestTests {
+		req, err := http.NewRequest(testData.method, testData.url, nil)
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+var blockRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/follow", "POST", http.StatusOK,
+		`"following":true,"followersCount":1,`,
+		"user2 follows user1 before being blocked",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/User2/block", "POST", http.StatusOK,
+		`{"profile":{"username":"user2","bio":"bio2","image":"http://image/2.jpg","following":false,"followersCount":0,"followingCount":0,"blocking":true,"muting":false}}`,
+		"block should end the follow",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user2/block", "POST", http.StatusOK,
+		`"blocking":true,"muting":false}}`,
+		"blocking twice should be fine",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/follow", "POST", http.StatusForbidden,
+		`{"errors":{"profile":"You are blocked"}}`,
+		"blocked user should not follow the blocker",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user2/follow", "POST", http.StatusForbidden,
+		`{"errors":{"profile":"Unblock the user first"}}`,
+		"blocker should not follow whom they blocked",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1", "GET", http.StatusOK,
+		`"following":false,"followersCount":0,"followingCount":0,"blocking":false,"muting":false}}`,
+		"blocked user should not be told about the block",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user2/block", "DELETE", http.StatusOK,
+		`"blocking":false,"muting":false}}`,
+		"unblock should work",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/follow", "POST", http.StatusOK,
+		`"following":true,`,
+		"unblocked user should follow again",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user3/mute", "POST", http.StatusOK,
+		`"blocking":false,"muting":true}}`,
+		"mute should work",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/profiles/user1/follow", "POST", http.StatusOK,
+		`"following":true,`,
+		"muted user should still follow",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user3/mute", "DELETE", http.StatusOK,
+		`"blocking":false,"muting":false}}`,
+		"unmute should work",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user1/block", "POST", http.StatusUnprocessableEntity,
+		`{"errors":{"profile":"That is you"}}`,
+		"user should not block themselves",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/nobody/mute", "POST", http.StatusNotFound,
+		`{"errors":{"profile":"Invalid username"}}`,
+		"unknown user should not be muted",
+	},
+	{
+		func(req *http.Request) {},
+		"/profiles/user2/block", "POST", http.StatusUnauthorized,
+		``,
+		"block should require auth",
+	},
+}
+
+func TestBlocksAnonymous(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	user1, user2, anonymous := UserModel{ID: 1}, UserModel{ID: 2}, UserModel{}
+	asserts.NoError(user1.block(user2))
+	asserts.NoError(user1.mute(UserModel{ID: 3}))
+
+	asserts.True(user1.IsBlocking(user2))
+	asserts.False(user1.IsBlocking(anonymous), "blocking someone should not block anonymous users")
+	asserts.False(anonymous.IsBlocking(user2), "anonymous users should block nobody")
+	asserts.False(user1.Hides(anonymous), "muting someone should not hide anonymous users")
+	asserts.False(anonymous.Hides(UserModel{ID: 3}), "anonymous users should mute nobody")
+}
+
+func TestBlocks(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(true))
+	ProfileRegister(r.Group("/profiles"))
+	for _, testData := range blockRequestTests {
+		req, err := http.NewRequest(testData.method, testData.url, nil)
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+var privateAccountRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	bodyData       string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 1)
+		},
+		"/user/", "PUT", `{"user":{"private":true}}`,
+		http.StatusOK, `{"user":{"username":"user1",.*"private":true}}`,
+		"user should make their account private",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/follow", "POST", ``,
+		http.StatusOK, `{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"private":true,"followersCount":0,"followingCount":0,"blocking":false,"muting":false,"requested":true}}`,
+		"following a private user should only request it",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/profiles/user1/follow", "POST", ``,
+		http.StatusOK, `"following":false,"private":true,.*"requested":true}}`,
+		"another user should request too",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/follow-requests", "GET", ``,
+		http.StatusOK, `{"followRequests":\[{"id":2,"profile":{"username":"user3",[^}]*},"createdAt":"[^"]+"},{"id":1,"profile":{"username":"user2",[^}]*},"createdAt":"[^"]+"}\],"followRequestsCount":2,"nextCursor":null,"prevCursor":null}`,
+		"follow requests should be listed, latest first",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/follow-requests/1", "POST", ``,
+		http.StatusNotFound, `{"errors":{"followRequest":"Invalid id"}}`,
+		"requester should not approve their own request",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/follow-requests/1", "POST", ``,
+		http.StatusOK, `{"profile":{"username":"user2",`,
+		"approve should answer with the requester",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1", "GET", ``,
+		http.StatusOK, `"following":true,"private":true,"followersCount":1,"followingCount":0,"blocking":false,"muting":false}}`,
+		"approved request should be a follow",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/follow-requests/2", "DELETE", ``,
+		http.StatusOK, `{"profile":{"username":"user3",`,
+		"reject should answer with the requester",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/profiles/user1", "GET", ``,
+		http.StatusOK, `"following":false,"private":true,"followersCount":1,"followingCount":0,"blocking":false,"muting":false}}`,
+		"rejected request should be gone",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/follow-requests/2", "POST", ``,
+		http.StatusNotFound, `{"errors":{"followRequest":"Invalid id"}}`,
+		"rejected request should not be approved",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/profiles/user1/follow", "POST", ``,
+		http.StatusOK, `"requested":true}}`,
+		"user should request again",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/profiles/user1/follow", "DELETE", ``,
+		http.StatusOK, `"following":false,"private":true,.*"muting":false}}`,
+		"unfollow should cancel the request",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/profiles/user1/follow", "POST", ``,
+		http.StatusOK, `"requested":true}}`,
+		"user should request once more",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/", "PUT", `{"user":{"private":false}}`,
+		http.StatusOK, `{"user":{"username":"user1",[^}]*"token":"[^"]+"}}`,
+		"user should make their account public again",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/profiles/user1", "GET", ``,
+		http.StatusOK, `"following":true,"followersCount":2,`,
+		"going public should approve pending requests",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/follow-requests", "GET", ``,
+		http.StatusOK, `{"followRequests":\[\],"followRequestsCount":0,`,
+		"no request should be left",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/follow-requests/x", "DELETE", ``,
+		http.StatusNotFound, `{"errors":{"followRequest":"Invalid id"}}`,
+		"bad id should be not found",
+	},
+}
+
+func TestPrivateProfileAnonymous(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+	test_db.Model(&UserModel{ID: 1}).Update("private", true)
+	asserts.NoError(UserModel{ID: 2}.requestFollow(UserModel{ID: 1}))
+	var userModel UserModel
+	test_db.First(&userModel, 1)
+
+	// Anonymous viewers get author profiles along with articles and comments
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("my_user_model", UserModel{})
+	serializer := ProfileSerializer{c, userModel}
+	asserts.False(serializer.Response().Requested, "anonymous users should not see the requests of others")
+
+	c.Set("my_user_model", UserModel{ID: 2})
+	asserts.True(serializer.Response().Requested, "the requester should see their request")
+}
+
+func TestPrivateAccounts(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(true))
+	UserRegister(r.Group("/user"))
+	ProfileRegister(r.Group("/profiles"))
+	for _, testData := range privateAccountRequestTests {
+		req, err := http.NewRequest(testData.method, testData.url, bytes.NewBufferString(testData.bodyData))
+		req.Header.Set("Content-Type", "application/json")
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+var notificationRequestTests = []struct {
+	init           func(*http.Request)
+	url            string
+	method         string
+	expectedCode   int
+	responseRegexg string
+	msg            string
+}{
+	{
+		func(req *http.Request) {
+			resetDBWithMock()
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/follow", "POST", http.StatusOK,
+		`"following":true,`,
+		"user2 follows user1",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/notifications", "GET", http.StatusOK,
+		`{"nextCursor":null,"notifications":\[{"id":1,"kind":"follow","message":"user2 followed you","actor":{"username":"user2","bio":"bio2","image":"http://image/2.jpg","following":false},"actorsCount":1,"article":null,"read":false,"createdAt":"[^"]+","updatedAt":"[^"]+"}\],"notificationsCount":1,"prevCursor":null,"unreadCount":1}`,
+		"follow should notify the followed user",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/profiles/user1/follow", "POST", http.StatusOK,
+		`"following":true,`,
+		"user3 follows user1 too",
+	},
+	{
+		func(req *http.Request) {
+			UserModel{ID: 2}.unFollowing(UserModel{ID: 1})
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/follow", "POST", http.StatusOK,
+		`"following":true,`,
+		"user2 follows user1 again",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/notifications", "GET", http.StatusOK,
+		`"notifications":\[{"id":1,"kind":"follow","message":"user3 and 1 other followed you","actor":{"username":"user3",.*"actorsCount":2,.*"notificationsCount":1,"prevCursor":null,"unreadCount":1}`,
+		"unread notification should gather the actors, each once",
+	},
+	{
+		func(req *http.Request) {
+			test_db.Create(&MuteModel{MuterID: 3, MutedID: 1})
+			HeaderTokenMock(req, 1)
+		},
+		"/profiles/user3/follow", "POST", http.StatusOK,
+		`"following":true,`,
+		"user1 follows user3, who muted them",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 3)
+		},
+		"/user/notifications", "GET", http.StatusOK,
+		`"notifications":\[\],"notificationsCount":0,"prevCursor":null,"unreadCount":0}`,
+		"muted users should not notify",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/user/notifications/1/read", "POST", http.StatusNotFound,
+		`{"errors":{"notification":"Invalid id"}}`,
+		"user should not read the notifications of others",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/notifications/1/read", "POST", http.StatusOK,
+		`{"notification":{"id":1,.*"read":true,.*},"unreadCount":0}`,
+		"user should mark a notification read",
+	},
+	{
+		func(req *http.Request) {
+			UserModel{ID: 2}.unFollowing(UserModel{ID: 1})
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/follow", "POST", http.StatusOK,
+		`"following":true,`,
+		"user2 follows user1 once more",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/notifications?unread=true", "GET", http.StatusOK,
+		`"notifications":\[{"id":2,"kind":"follow","message":"user2 followed you",.*"notificationsCount":1,"prevCursor":null,"unreadCount":1}`,
+		"actor after reading should start a new notification",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/notifications?limit=1", "GET", http.StatusOK,
+		`{"nextCursor":"[^"]+","notifications":\[{"id":2,.*"notificationsCount":2,`,
+		"notifications should be paged, latest first",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/notifications/read", "POST", http.StatusOK,
+		`{"read":1,"unreadCount":0}`,
+		"user should mark every notification read",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 2)
+		},
+		"/profiles/user1/follow", "POST", http.StatusOK,
+		`"following":true,`,
+		"user2 follows user1 while already following",
+	},
+	{
+		func(req *http.Request) {
+			test_db.Model(&UserModel{ID: 1}).Update("private", true)
+			UserModel{ID: 3}.unFollowing(UserModel{ID: 1})
+			UserModel{ID: 3}.requestFollow(UserModel{ID: 1})
+			HeaderTokenMock(req, 1)
+		},
+		"/user/follow-requests/1", "POST", http.StatusOK,
+		`"username":"user3"`,
+		"user1 approves the follow request of user3",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/notifications?unread=true", "GET", http.StatusOK,
+		`"notifications":\[\],"notificationsCount":0,"prevCursor":null,"unreadCount":0}`,
+		"following again and approved follow requests should not notify",
+	},
+	{
+		func(req *http.Request) {
+			HeaderTokenMock(req, 1)
+		},
+		"/user/notifications/x/read", "POST", http.StatusNotFound,
+		`{"errors":{"notification":"Invalid id"}}`,
+		"bad id should be not found",
+	},
+}
+
+func TestNotifications(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(true))
+	UserRegister(r.Group("/user"))
+	ProfileRegister(r.Group("/profiles"))
+	for _, testData := range notificationRequestTests {
+		req, err := http.NewRequest(testData.method, testData.url, nil)
+		asserts.NoError(err)
+
+		testData.init(req)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		asserts.Equal(testData.expectedCode, w.Code, "Response Status - "+testData.msg)
+		asserts.Regexp(testData.responseRegexg, w.Body.String(), "Response Content - "+testData.msg)
+	}
+}
+
+func TestNotificationStream(t *testing.T) {
+	asserts := assert.New(t)
+	resetDBWithMock()
+
+	r := gin.New()
+	r.Use(AuthMiddleware(true))
+	UserRegister(r.Group("/user"))
+	ProfileRegister(r.Group("/profiles"))
+	server := httptest.NewServer(r)
+	defer server.Close()
+	follow := func(user uint) {
+		req, _ := http.NewRequest("POST", "/profiles/user1/follow", nil)
+		HeaderTokenMock(req, user)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusOK, w.Code)
+	}
+
+	// EventSource can't send headers, so the token goes in the query
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/user/notifications/stream?access_token="+common.GenToken(1), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(http.StatusOK, resp.StatusCode)
+	stream := bufio.NewReader(resp.Body)
+	nextData := func() string {
+		for {
+			line, err := stream.ReadString('\n')
+			if err != nil || strings.HasPrefix(line, "data:") {
+				return line
+			}
+		}
+	}
+
+	follow(2)
+	asserts.Regexp(`^data:{"id":1,"kind":"follow","message":"user2 followed you",`, nextData(), "new notification should be streamed")
+	follow(3)
+	asserts.Regexp(`"message":"user3 and 1 other followed you",.*"actorsCount":2,`, nextData(), "grown notification should be streamed again")
+
+	cancel()
+	asserts.Eventually(func() bool { return common.GetHub().Subscribers(NotificationsTopic(1)) == 0 }, time.Second, 10*time.Millisecond, "closed stream should unsubscribe")
+
+	req, _ = http.NewRequest("GET", "/user/notifications/stream", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnauthorized, w.Code, "stream should require auth")
+}
+
 //This is a hack way to add test database for each case, as whole test will just share one database.
 //You can read TestWithoutAuth's comment to know how to not share database each case.
 func TestMain(m *testing.M) {
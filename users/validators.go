@@ -16,6 +16,7 @@ type UserModelValidator struct {
 		Password string `form:"password" json:"password" binding:"required,min=8,max=255"`
 		Bio      string `form:"bio" json:"bio" binding:"max=1024"`
 		Image    string `form:"image" json:"image" binding:"omitempty,url"`
+		Private  *bool  `form:"private" json:"private"`
 	} `json:"user"`
 	userModel UserModel `json:"-"`
 }
@@ -38,6 +39,9 @@ func (self *UserModelValidator) Bind(c *gin.Context) error {
 	if self.User.Image != "" {
 		self.userModel.Image = &self.User.Image
 	}
+	if self.User.Private != nil {
+		self.userModel.Private = *self.User.Private
+	}
 	return nil
 }
 
@@ -54,6 +58,7 @@ func NewUserModelValidatorFillWith(userModel UserModel) UserModelValidator {
 	userModelValidator.User.Email = userModel.Email
 	userModelValidator.User.Bio = userModel.Bio
 	userModelValidator.User.Password = common.NBRandomPassword
+	userModelValidator.User.Private = &userModel.Private
 
 	if userModel.Image != nil {
 		userModelValidator.User.Image = *userModel.Image
@@ -84,3 +89,16 @@ func NewLoginValidator() LoginValidator {
 	loginValidator := LoginValidator{}
 	return loginValidator
 }
+
+// Used by both refresh and logout, logout doesn't require it.
+type RefreshTokenValidator struct {
+	RefreshToken string `form:"refreshToken" json:"refreshToken" binding:"required"`
+}
+
+func (self *RefreshTokenValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewRefreshTokenValidator() RefreshTokenValidator {
+	return RefreshTokenValidator{}
+}